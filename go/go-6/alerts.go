@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/mre/goforecast/internal/weather"
+)
+
+// Alerter looks up severe-weather warnings, which open-meteo doesn't
+// provide, for a location.
+type Alerter interface {
+	Alerts(ctx context.Context, latLong weather.LatLong) ([]weather.Alert, error)
+}
+
+// httpAlerter asks an alerts service for the warnings at a location. The
+// endpoint gets latitude and longitude query parameters and must answer
+// with {"alerts": [{"title", "severity", "description", "start", "end"}]},
+// times in RFC 3339.
+type httpAlerter struct {
+	endpoint string
+	client   *http.Client
+}
+
+type alertsResponse struct {
+	Alerts []struct {
+		Title       string     `json:"title"`
+		Severity    string     `json:"severity"`
+		Description string     `json:"description"`
+		Start       time.Time  `json:"start"`
+		End         *time.Time `json:"end"`
+	} `json:"alerts"`
+}
+
+func (a *httpAlerter) Alerts(ctx context.Context, latLong weather.LatLong) ([]weather.Alert, error) {
+	endpoint, err := url.Parse(a.endpoint)
+	if err != nil {
+		return nil, err
+	}
+	query := endpoint.Query()
+	query.Set("latitude", strconv.FormatFloat(latLong.Latitude, 'f', 4, 64))
+	query.Set("longitude", strconv.FormatFloat(latLong.Longitude, 'f', 4, 64))
+	endpoint.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error making request to alerts service: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("alerts service returned %s", resp.Status)
+	}
+
+	var response alertsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("error decoding alerts response: %w", err)
+	}
+	alerts := make([]weather.Alert, 0, len(response.Alerts))
+	for _, alert := range response.Alerts {
+		alerts = append(alerts, weather.Alert{
+			Time:        alert.Start,
+			Date:        alert.Start.Format("Mon, 2 Jan 15:04"),
+			Kind:        "warning",
+			Title:       alert.Title,
+			Severity:    alert.Severity,
+			Description: alert.Description,
+			Until:       alert.End,
+		})
+	}
+	return alerts, nil
+}
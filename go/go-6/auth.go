@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// adminAuth protects the admin endpoints. With JWT_SECRET set it expects an
+// HMAC-signed bearer token; otherwise it falls back to basic auth.
+func adminAuth(cfg Config) gin.HandlerFunc {
+	if cfg.JWTSecret == "" {
+		return gin.BasicAuth(gin.Accounts{
+			"forecast": "forecast",
+		})
+	}
+	name, value, _ := strings.Cut(cfg.JWTRequiredClaim, "=")
+	return jwtAuth([]byte(cfg.JWTSecret), name, value)
+}
+
+// jwtAuth accepts requests carrying an unexpired HMAC-signed bearer token
+// whose claim named claim equals value.
+func jwtAuth(secret []byte, claim, value string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenString, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok {
+			c.Header("WWW-Authenticate", `Bearer realm="forecast"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing bearer token"})
+			return
+		}
+
+		token, err := jwt.Parse(tokenString, func(*jwt.Token) (interface{}, error) {
+			return secret, nil
+		}, jwt.WithValidMethods([]string{"HS256", "HS384", "HS512"}), jwt.WithExpirationRequired())
+		if err != nil {
+			c.Header("WWW-Authenticate", `Bearer realm="forecast", error="invalid_token"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token: " + err.Error()})
+			return
+		}
+
+		claims, _ := token.Claims.(jwt.MapClaims)
+		if got, _ := claims[claim].(string); got != value {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "token lacks required claim " + claim})
+			return
+		}
+		c.Next()
+	}
+}
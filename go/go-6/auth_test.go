@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestJWTAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	secret := []byte("hunter2")
+	r := gin.New()
+	r.GET("/stats", adminAuth(Config{JWTSecret: string(secret), JWTRequiredClaim: "role=admin"}), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	sign := func(claims jwt.MapClaims, key []byte) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return "Bearer " + token
+	}
+	hour := time.Now().Add(time.Hour).Unix()
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+	}{
+		{"valid token", sign(jwt.MapClaims{"role": "admin", "exp": hour}, secret), http.StatusOK},
+		{"expired token", sign(jwt.MapClaims{"role": "admin", "exp": time.Now().Add(-time.Minute).Unix()}, secret), http.StatusUnauthorized},
+		{"no expiry", sign(jwt.MapClaims{"role": "admin"}, secret), http.StatusUnauthorized},
+		{"wrong secret", sign(jwt.MapClaims{"role": "admin", "exp": hour}, []byte("letmein")), http.StatusUnauthorized},
+		{"missing claim", sign(jwt.MapClaims{"exp": hour}, secret), http.StatusForbidden},
+		{"wrong claim", sign(jwt.MapClaims{"role": "viewer", "exp": hour}, secret), http.StatusForbidden},
+		{"missing token", "", http.StatusUnauthorized},
+		{"basic auth", "Basic Zm9yZWNhc3Q6Zm9yZWNhc3Q=", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/stats", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("got %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 without a WWW-Authenticate header")
+			}
+		})
+	}
+}
+
+func TestAdminAuthFallsBackToBasic(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/stats", adminAuth(Config{}), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	req := httptest.NewRequest(http.MethodGet, "/stats", nil)
+	req.SetBasicAuth("forecast", "forecast")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("basic auth without JWT_SECRET: got %d, want 200", rec.Code)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// backgroundTasks tracks work that outlives the request that started it,
+// such as early cache refreshes, so shutdown can wait for it instead of
+// cutting writes off halfway. A nil *backgroundTasks runs work untracked.
+type backgroundTasks struct {
+	sync.WaitGroup
+}
+
+// Go runs fn in a new goroutine.
+func (b *backgroundTasks) Go(fn func()) {
+	if b == nil {
+		go fn()
+		return
+	}
+	b.Add(1)
+	go func() {
+		defer b.Done()
+		fn()
+	}()
+}
+
+// Drain waits up to timeout for all tracked work to finish and reports
+// whether it did.
+func (b *backgroundTasks) Drain(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		b.Wait()
+		close(done)
+	}()
+
+	slog.Info("waiting for background work", "timeout", timeout)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
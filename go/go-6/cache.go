@@ -0,0 +1,376 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mre/goforecast/internal/weather"
+)
+
+// upstreamGenerationTime is open-meteo's own generationtime_ms for the last
+// forecast fetched, excluding network time.
+var upstreamGenerationTime = expvar.NewFloat("upstream_generation_ms")
+
+var (
+	ErrCacheMiss = errors.New("cache miss")
+	ErrTooStale  = errors.New("weather service unavailable and cached forecast is too old")
+)
+
+// CacheEntry is a cached upstream response together with its bookkeeping.
+type CacheEntry struct {
+	Value     []byte    `db:"value"`
+	FetchedAt time.Time `db:"fetched_at"`
+	ExpiresAt time.Time `db:"expires_at"`
+}
+
+// Cache stores raw upstream responses so repeated lookups don't have to hit
+// open-meteo. Get returns ErrCacheMiss when there is no entry for key, Delete
+// reports whether there was an entry to remove and DeletePrefix how many
+// entries with keys starting with prefix it removed. Stats reports the
+// counters since start or the last reset.
+type Cache interface {
+	Get(key string) (CacheEntry, error)
+	Set(key string, value []byte, ttl time.Duration) error
+	Delete(key string) (bool, error)
+	DeletePrefix(prefix string) (int64, error)
+	Stats(reset bool) (CacheStats, error)
+}
+
+// CacheStats describes how well a Cache is doing. A read of an expired entry
+// counts as a miss; evictions are entries deleted before being replaced.
+type CacheStats struct {
+	Hits      int64   `json:"hits"`
+	Misses    int64   `json:"misses"`
+	HitRatio  float64 `json:"hit_ratio"`
+	Size      int64   `json:"size"`
+	Evictions int64   `json:"evictions"`
+}
+
+// dbCache is a Cache backed by the weather_cache table. Every key is
+// prefixed so that several deployments can share one database. Lookups go to
+// reads, which may be a replica; a lagging one only costs an extra fetch.
+type dbCache struct {
+	db     *timeoutDB
+	reads  *timeoutDB
+	prefix string
+
+	hits, misses, evictions atomic.Int64
+}
+
+func newDBCache(db, reads *timeoutDB, prefix string) *dbCache {
+	return &dbCache{db: db, reads: reads, prefix: prefix}
+}
+
+func (c *dbCache) Get(key string) (CacheEntry, error) {
+	var entry CacheEntry
+	err := c.reads.Get(&entry, "SELECT value, fetched_at, expires_at FROM weather_cache WHERE key = $1", c.prefix+key)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.misses.Add(1)
+		return CacheEntry{}, ErrCacheMiss
+	}
+	if err != nil {
+		return CacheEntry{}, err
+	}
+	if time.Now().Before(entry.ExpiresAt) {
+		c.hits.Add(1)
+	} else {
+		c.misses.Add(1)
+	}
+	return entry, nil
+}
+
+func (c *dbCache) Set(key string, value []byte, ttl time.Duration) error {
+	now := time.Now()
+	_, err := c.db.Exec(`INSERT INTO weather_cache (key, value, fetched_at, expires_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, fetched_at = EXCLUDED.fetched_at, expires_at = EXCLUDED.expires_at`,
+		c.prefix+key, value, now, now.Add(ttl))
+	return err
+}
+
+func (c *dbCache) Delete(key string) (bool, error) {
+	res, err := c.db.Exec("DELETE FROM weather_cache WHERE key = $1", c.prefix+key)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	c.evictions.Add(n)
+	return n > 0, err
+}
+
+func (c *dbCache) DeletePrefix(prefix string) (int64, error) {
+	res, err := c.db.Exec(`DELETE FROM weather_cache WHERE key LIKE $1`, likeEscaper.Replace(c.prefix+prefix)+"%")
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	c.evictions.Add(n)
+	return n, err
+}
+
+func (c *dbCache) Stats(reset bool) (CacheStats, error) {
+	var stats CacheStats
+	if err := c.reads.Get(&stats.Size, `SELECT count(*) FROM weather_cache WHERE key LIKE $1`, likeEscaper.Replace(c.prefix)+"%"); err != nil {
+		return CacheStats{}, err
+	}
+	if reset {
+		stats.Hits, stats.Misses, stats.Evictions = c.hits.Swap(0), c.misses.Swap(0), c.evictions.Swap(0)
+	} else {
+		stats.Hits, stats.Misses, stats.Evictions = c.hits.Load(), c.misses.Load(), c.evictions.Load()
+	}
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRatio = float64(stats.Hits) / float64(total)
+	}
+	return stats, nil
+}
+
+// weatherCache applies the caching policy for forecasts on top of a Cache.
+type weatherCache struct {
+	queue *upstreamQueue
+	cache Cache
+	ttl   time.Duration
+	// maxStale is how old an expired entry may be and still be served as a
+	// last-known-good fallback while open-meteo can't be reached.
+	maxStale time.Duration
+	// history, if set, receives a copy of every forecast fetched.
+	history *forecastHistory
+	// beta tunes early refresh (XFetch): as an entry nears expiry, requests
+	// refresh it in the background with a probability that grows the closer
+	// expiry is and the longer fetches take, scaled by beta. Larger values
+	// refresh earlier; zero disables early refresh.
+	beta float64
+	// refreshWindow, if set, refreshes an entry in the background whenever it
+	// is read less than this long before it expires. Unlike beta it is
+	// deterministic: the first read inside the window triggers the refresh.
+	refreshWindow time.Duration
+	// softTimeout, if set, bounds how long a request for an expired entry
+	// waits on open-meteo before being answered from the cache instead.
+	// Entries older than maxStale are never served this way.
+	softTimeout time.Duration
+
+	// lastFetch is how long the most recent upstream fetch took, in
+	// nanoseconds.
+	lastFetch atomic.Int64
+	// refreshing holds the keys with a background refresh in flight.
+	refreshing sync.Map
+	// background tracks fetches that continue after the request that
+	// started them.
+	background *backgroundTasks
+}
+
+// cacheGridDegrees is the grid coordinates are snapped to for cache keys.
+// Geocoding results for the same place differ in the last decimals, and
+// open-meteo's models don't resolve finer than this anyway.
+const cacheGridDegrees = 0.25
+
+// weatherCacheKey identifies the forecast for latLong requested with params.
+// Nearby coordinates in the same grid cell share a key; the forecast stored
+// under it is whichever was fetched first for a point in that cell. The
+// normalized request parameters are part of the key, so forecasts for a
+// different number of days or set of variables never collide.
+func weatherCacheKey(latLong weather.LatLong, params weather.ForecastParams) string {
+	return weatherCachePrefix(latLong) + params.Encode()
+}
+
+// weatherCachePrefix is shared by the keys of all forecasts for latLong's
+// grid cell.
+func weatherCachePrefix(latLong weather.LatLong) string {
+	return fmt.Sprintf("weather:%.2f,%.2f:", snapToGrid(latLong.Latitude), snapToGrid(latLong.Longitude))
+}
+
+func snapToGrid(v float64) float64 {
+	snapped := math.Round(v/cacheGridDegrees) * cacheGridDegrees
+	if snapped == 0 {
+		// Avoid a separate "-0.00" key just south or west of zero.
+		return 0
+	}
+	return snapped
+}
+
+// Cache outcomes reported in lookupInfo.
+const (
+	cacheHit   = "HIT"
+	cacheMiss  = "MISS"
+	cacheStale = "STALE"
+)
+
+// lookupInfo describes how a forecast was obtained: whether it came from the
+// cache (HIT), from open-meteo (MISS) or from an expired entry served because
+// open-meteo failed or was slow (STALE), how long the request waited on
+// open-meteo, and when the forecast served was fetched and expires.
+type lookupInfo struct {
+	Cache     string
+	Upstream  time.Duration
+	FetchedAt time.Time
+	ExpiresAt time.Time
+}
+
+// Get returns the forecast for latLong and params from the cache, fetching
+// and storing a fresh copy when there is no entry or it has expired. If the
+// fetch fails, an expired entry younger than maxStale is served instead;
+// anything older yields ErrTooStale. The cache holds raw responses; only the
+// one returned is decoded.
+func (w *weatherCache) Get(ctx context.Context, latLong weather.LatLong, params weather.ForecastParams) (*weather.WeatherResponse, error) {
+	forecast, _, err := w.Lookup(ctx, latLong, params)
+	return forecast, err
+}
+
+// Lookup is Get, also reporting how the forecast was obtained.
+func (w *weatherCache) Lookup(ctx context.Context, latLong weather.LatLong, params weather.ForecastParams) (*weather.WeatherResponse, lookupInfo, error) {
+	key := weatherCacheKey(latLong, params)
+	entry, cacheErr := w.cache.Get(key)
+	if cacheErr == nil && time.Now().Before(entry.ExpiresAt) {
+		slog.Debug("weather cache hit", "key", key, "expires", entry.ExpiresAt)
+		forecast, err := weather.DecodeWeather(entry.Value)
+		if err == nil {
+			if w.inRefreshWindow(entry) || w.refreshEarly(entry) {
+				w.refreshInBackground(key, latLong, params)
+			}
+			return forecast, lookupInfo{Cache: cacheHit, FetchedAt: entry.FetchedAt, ExpiresAt: entry.ExpiresAt}, nil
+		}
+		slog.Warn("discarding undecodable weather cache entry", "key", key, "error", err)
+		cacheErr = err
+	}
+	switch {
+	case errors.Is(cacheErr, ErrCacheMiss):
+		slog.Debug("weather cache miss", "key", key)
+	case cacheErr != nil:
+		slog.Warn("error reading weather cache", "key", key, "error", cacheErr)
+	default:
+		slog.Debug("weather cache entry expired", "key", key, "expired", entry.ExpiresAt)
+	}
+
+	var forecast *weather.WeatherResponse
+	var err error
+	start := time.Now()
+	if cacheErr == nil && w.softTimeout > 0 && time.Since(entry.FetchedAt) <= w.maxStale {
+		var slow bool
+		forecast, slow, err = w.fetchWithin(ctx, key, latLong, params, w.softTimeout)
+		if slow {
+			slog.Info("weather service slow, serving cached forecast", "key", key, "age", time.Since(entry.FetchedAt))
+			return w.serveStale(entry, time.Since(start))
+		}
+	} else {
+		forecast, err = w.fetch(ctx, key, latLong, params)
+	}
+	info := lookupInfo{Cache: cacheMiss, Upstream: time.Since(start)}
+	if err != nil {
+		if cacheErr != nil {
+			return nil, info, err
+		}
+		age := time.Since(entry.FetchedAt)
+		if age > w.maxStale {
+			return nil, info, fmt.Errorf("%w (%s old): %v", ErrTooStale, age.Round(time.Second), err)
+		}
+		slog.Warn("serving stale forecast", "key", key, "age", age, "error", err)
+		return w.serveStale(entry, info.Upstream)
+	}
+	info.FetchedAt = time.Now()
+	info.ExpiresAt = info.FetchedAt.Add(w.ttl)
+	return forecast, info, nil
+}
+
+// serveStale decodes an expired entry to be served in place of a fresh
+// forecast.
+func (w *weatherCache) serveStale(entry CacheEntry, upstream time.Duration) (*weather.WeatherResponse, lookupInfo, error) {
+	forecast, err := weather.DecodeWeather(entry.Value)
+	return forecast, lookupInfo{Cache: cacheStale, Upstream: upstream, FetchedAt: entry.FetchedAt, ExpiresAt: entry.ExpiresAt}, err
+}
+
+// fetchWithin runs fetch but stops waiting for it after timeout, reporting
+// slow. The fetch keeps going in the background and still updates the cache
+// when it completes, so it isn't cancelled along with ctx.
+func (w *weatherCache) fetchWithin(ctx context.Context, key string, latLong weather.LatLong, params weather.ForecastParams, timeout time.Duration) (forecast *weather.WeatherResponse, slow bool, err error) {
+	type result struct {
+		forecast *weather.WeatherResponse
+		err      error
+	}
+	done := make(chan result, 1)
+	ctx = context.WithoutCancel(ctx)
+	w.background.Go(func() {
+		forecast, err := w.fetch(ctx, key, latLong, params)
+		if err != nil {
+			slog.Debug("forecast fetch failed", "key", key, "error", err)
+		}
+		done <- result{forecast, err}
+	})
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.forecast, false, r.err
+	case <-timer.C:
+		return nil, true, nil
+	}
+}
+
+// fetch gets a fresh forecast from open-meteo and stores it under key.
+func (w *weatherCache) fetch(ctx context.Context, key string, latLong weather.LatLong, params weather.ForecastParams) (*weather.WeatherResponse, error) {
+	var forecast *weather.WeatherResponse
+	start := time.Now()
+	err := w.queue.Do(ctx, func() (err error) {
+		slog.Debug("fetching forecast", "url", weather.ForecastURL(latLong, params))
+		forecast, err = weather.GetWeather(ctx, latLong, params)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	elapsed := time.Since(start)
+	w.lastFetch.Store(int64(elapsed))
+	upstreamGenerationTime.Set(forecast.GenerationTimeMs)
+	slog.Debug("fetched forecast", "key", key, "elapsed", elapsed, "generationtime_ms", forecast.GenerationTimeMs)
+
+	if err := w.cache.Set(key, forecast.Raw, w.ttl); err != nil {
+		slog.Warn("error writing weather cache", "key", key, "error", err)
+	}
+	if w.history != nil {
+		if err := w.history.Add(key, forecast.Raw); err != nil {
+			slog.Warn("error recording forecast history", "key", key, "error", err)
+		}
+	}
+	return forecast, nil
+}
+
+// refreshEarly decides whether entry should be refreshed before it expires,
+// following the XFetch algorithm: refresh once now - delta*beta*ln(rand)
+// passes the expiry, where delta is how long a fetch takes. Requests spread
+// the refresh over the last moments of an entry's life instead of all
+// missing at once when it expires.
+func (w *weatherCache) refreshEarly(entry CacheEntry) bool {
+	if w.beta <= 0 {
+		return false
+	}
+	delta := time.Duration(w.lastFetch.Load())
+	gap := time.Duration(float64(delta) * w.beta * -math.Log(rand.Float64()))
+	return !time.Now().Add(gap).Before(entry.ExpiresAt)
+}
+
+// inRefreshWindow reports whether entry expires within refreshWindow.
+func (w *weatherCache) inRefreshWindow(entry CacheEntry) bool {
+	return w.refreshWindow > 0 && time.Until(entry.ExpiresAt) < w.refreshWindow
+}
+
+// refreshInBackground fetches a fresh copy of key unless a refresh for it is
+// already running. Callers keep serving the current entry meanwhile.
+func (w *weatherCache) refreshInBackground(key string, latLong weather.LatLong, params weather.ForecastParams) {
+	if _, running := w.refreshing.LoadOrStore(key, true); running {
+		return
+	}
+	w.background.Go(func() {
+		defer w.refreshing.Delete(key)
+		slog.Debug("refreshing forecast early", "key", key)
+		if _, err := w.fetch(context.Background(), key, latLong, params); err != nil {
+			slog.Warn("early refresh failed", "key", key, "error", err)
+		}
+	})
+}
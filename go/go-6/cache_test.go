@@ -0,0 +1,482 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mre/goforecast/internal/weather"
+)
+
+// memoryCache is a Cache kept in a map, for tests.
+type memoryCache struct {
+	mu      sync.Mutex
+	entries map[string]CacheEntry
+}
+
+func newMemoryCache() *memoryCache {
+	return &memoryCache{entries: make(map[string]CacheEntry)}
+}
+
+func (c *memoryCache) Get(key string) (CacheEntry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return CacheEntry{}, ErrCacheMiss
+	}
+	return entry, nil
+}
+
+func (c *memoryCache) Set(key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	c.entries[key] = CacheEntry{Value: value, FetchedAt: now, ExpiresAt: now.Add(ttl)}
+	return nil
+}
+
+func (c *memoryCache) Delete(key string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.entries[key]
+	delete(c.entries, key)
+	return ok, nil
+}
+
+func (c *memoryCache) DeletePrefix(prefix string) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var n int64
+	for key := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(c.entries, key)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (c *memoryCache) Stats(bool) (CacheStats, error) { return CacheStats{}, nil }
+
+const testForecast = `{"timezone":"GMT","hourly":{"time":["2024-01-01T00:00"],"temperature_2m":[1.5]}}`
+
+// serveUpstream points open-meteo at handler for the rest of the test.
+func serveUpstream(t *testing.T, handler http.HandlerFunc) {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	geocoding, forecast := weather.GeocodingEndpoint, weather.ForecastEndpoint
+	weather.GeocodingEndpoint, weather.ForecastEndpoint = server.URL, server.URL
+	t.Cleanup(func() { weather.GeocodingEndpoint, weather.ForecastEndpoint = geocoding, forecast })
+}
+
+func newTestWeatherCache(cache Cache) *weatherCache {
+	retry := &retrier{budget: newRetryBudget(0)}
+	return &weatherCache{
+		queue:    newUpstreamQueue(1, 1, retry),
+		cache:    cache,
+		ttl:      time.Minute,
+		maxStale: time.Hour,
+	}
+}
+
+func TestWeatherCacheOutage(t *testing.T) {
+	latLong := weather.LatLong{Latitude: 52.52, Longitude: 13.41}
+	key := weatherCacheKey(latLong, weather.ForecastParams{})
+	tests := []struct {
+		name      string
+		cachedAge time.Duration // zero for no cached entry
+		wantCache string
+		wantErr   error
+	}{
+		{"no cached forecast", 0, "", nil},
+		{"within max stale", 30 * time.Minute, cacheStale, nil},
+		{"beyond max stale", 2 * time.Hour, "", ErrTooStale},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serveUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write([]byte(`{"error":true,"reason":"down for maintenance"}`))
+			})
+			cache := newMemoryCache()
+			if tt.cachedAge > 0 {
+				fetched := time.Now().Add(-tt.cachedAge)
+				cache.entries[key] = CacheEntry{Value: []byte(testForecast), FetchedAt: fetched, ExpiresAt: fetched.Add(time.Minute)}
+			}
+
+			forecast, info, err := newTestWeatherCache(cache).Lookup(context.Background(), latLong, weather.ForecastParams{})
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got error %v, want %v", err, tt.wantErr)
+				}
+			case tt.wantCache == "":
+				var statusErr *weather.StatusError
+				if !errors.As(err, &statusErr) || statusErr.Code != http.StatusServiceUnavailable {
+					t.Fatalf("got error %v, want a 503 *weather.StatusError", err)
+				}
+				if errorStatus(err) != http.StatusBadGateway {
+					t.Errorf("errorStatus = %d, want %d", errorStatus(err), http.StatusBadGateway)
+				}
+			default:
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if info.Cache != tt.wantCache || len(forecast.Hourly.Time) != 1 {
+					t.Errorf("got %s forecast with %d hours, want %s with 1", info.Cache, len(forecast.Hourly.Time), tt.wantCache)
+				}
+			}
+
+			entry, err := cache.Get(key)
+			if tt.cachedAge == 0 {
+				if !errors.Is(err, ErrCacheMiss) {
+					t.Errorf("error response was cached: %q", entry.Value)
+				}
+			} else if string(entry.Value) != testForecast {
+				t.Errorf("cached forecast replaced by %q", entry.Value)
+			}
+		})
+	}
+}
+
+func TestWeatherCacheFetch(t *testing.T) {
+	serveUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testForecast))
+	})
+	cache := newMemoryCache()
+	w := newTestWeatherCache(cache)
+	latLong := weather.LatLong{Latitude: 52.52, Longitude: 13.41}
+
+	for _, want := range []string{cacheMiss, cacheHit} {
+		_, info, err := w.Lookup(context.Background(), latLong, weather.ForecastParams{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if info.Cache != want {
+			t.Errorf("got %s, want %s", info.Cache, want)
+		}
+	}
+}
+
+func TestWeatherCacheKeyedByParams(t *testing.T) {
+	var requested []string
+	serveUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Query().Get("forecast_days"))
+		w.Write([]byte(testForecast))
+	})
+	cache := newMemoryCache()
+	w := newTestWeatherCache(cache)
+	latLong := weather.LatLong{Latitude: 52.52, Longitude: 13.41}
+
+	tests := []struct {
+		params weather.ForecastParams
+		want   string
+	}{
+		{weather.ForecastParams{Days: 3}, cacheMiss},
+		{weather.ForecastParams{Days: 7}, cacheMiss},
+		{weather.ForecastParams{Days: 7}, cacheHit},
+		{weather.ForecastParams{Days: 3}, cacheHit},
+		// Leaving the days out asks for the default, which is 3.
+		{weather.ForecastParams{}, cacheHit},
+	}
+	for _, tt := range tests {
+		_, info, err := w.Lookup(context.Background(), latLong, tt.params)
+		if err != nil {
+			t.Fatalf("%+v: unexpected error: %v", tt.params, err)
+		}
+		if info.Cache != tt.want {
+			t.Errorf("%+v: got %s, want %s", tt.params, info.Cache, tt.want)
+		}
+	}
+	if want := []string{"3", "7"}; !slices.Equal(requested, want) {
+		t.Errorf("fetched forecast_days %q, want %q", requested, want)
+	}
+	if len(cache.entries) != 2 {
+		t.Errorf("%d cache entries, want one per day count", len(cache.entries))
+	}
+}
+
+// lookupConcurrently looks up latLong from n goroutines at once and fails
+// the test unless every one is served from the cache.
+func lookupConcurrently(t *testing.T, w *weatherCache, latLong weather.LatLong, n int) {
+	t.Helper()
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, info, err := w.Lookup(context.Background(), latLong, weather.ForecastParams{})
+			if err != nil || info.Cache != cacheHit {
+				t.Errorf("got %s, error %v; want the cached forecast", info.Cache, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestWeatherCacheRefreshesEarlyOnce(t *testing.T) {
+	latLong := weather.LatLong{Latitude: 52.52, Longitude: 13.41}
+	key := weatherCacheKey(latLong, weather.ForecastParams{})
+	tests := []struct {
+		name      string
+		beta      float64
+		expiresIn time.Duration
+		want      int32
+	}{
+		{"near expiry", 1, time.Second, 1},
+		{"far from expiry", 1, 365 * 24 * time.Hour, 0},
+		{"disabled", 0, time.Second, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fetches atomic.Int32
+			release := make(chan struct{})
+			serveUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+				fetches.Add(1)
+				<-release
+				w.Write([]byte(testForecast))
+			})
+			cache := newMemoryCache()
+			now := time.Now()
+			cache.entries[key] = CacheEntry{Value: []byte(testForecast), FetchedAt: now.Add(-time.Minute), ExpiresAt: now.Add(tt.expiresIn)}
+			w := newTestWeatherCache(cache)
+			w.beta = tt.beta
+			w.background = &backgroundTasks{}
+			// With fetches this slow, any entry expiring within seconds is
+			// all but certain to be picked for refresh.
+			w.lastFetch.Store(int64(24 * time.Hour))
+
+			// The refresh is held up until every request has been served,
+			// so they all see it in flight.
+			lookupConcurrently(t, w, latLong, 20)
+			close(release)
+			w.background.Wait()
+
+			if got := fetches.Load(); got != tt.want {
+				t.Errorf("%d refreshes, want %d", got, tt.want)
+			}
+			if tt.want > 0 && !cache.entries[key].FetchedAt.After(now) {
+				t.Error("refresh didn't replace the cached forecast")
+			}
+		})
+	}
+}
+
+func TestWeatherCacheRefreshWindow(t *testing.T) {
+	latLong := weather.LatLong{Latitude: 52.52, Longitude: 13.41}
+	key := weatherCacheKey(latLong, weather.ForecastParams{})
+	tests := []struct {
+		name      string
+		window    time.Duration
+		expiresIn time.Duration
+		want      int32
+	}{
+		{"within the window", 30 * time.Second, 10 * time.Second, 1},
+		{"before the window", 30 * time.Second, 50 * time.Second, 0},
+		{"no window", 0, 10 * time.Second, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fetches atomic.Int32
+			release := make(chan struct{})
+			serveUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+				fetches.Add(1)
+				<-release
+				w.Write([]byte(testForecast))
+			})
+			cache := newMemoryCache()
+			now := time.Now()
+			cache.entries[key] = CacheEntry{Value: []byte(testForecast), FetchedAt: now.Add(-50 * time.Second), ExpiresAt: now.Add(tt.expiresIn)}
+			w := newTestWeatherCache(cache)
+			w.refreshWindow = tt.window
+			w.background = &backgroundTasks{}
+
+			lookupConcurrently(t, w, latLong, 20)
+			close(release)
+			w.background.Wait()
+
+			if got := fetches.Load(); got != tt.want {
+				t.Errorf("%d background fetches, want %d", got, tt.want)
+			}
+			if tt.want > 0 && !cache.entries[key].ExpiresAt.After(now.Add(tt.expiresIn)) {
+				t.Error("refresh didn't replace the cached forecast")
+			}
+		})
+	}
+}
+
+func TestWeatherCachePrefix(t *testing.T) {
+	tests := []struct {
+		name    string
+		latLong weather.LatLong
+		want    string
+	}{
+		{"on the grid", weather.LatLong{Latitude: 52.5, Longitude: 13.25}, "weather:52.50,13.25:"},
+		{"snaps to the nearest point", weather.LatLong{Latitude: 52.52, Longitude: 13.41}, "weather:52.50,13.50:"},
+		{"geocoding jitter", weather.LatLong{Latitude: 52.5244, Longitude: 13.4105}, "weather:52.50,13.50:"},
+		{"rounds half away from zero", weather.LatLong{Latitude: 52.625, Longitude: -0.125}, "weather:52.75,-0.25:"},
+		{"southern and western", weather.LatLong{Latitude: -33.87, Longitude: -151.21}, "weather:-33.75,-151.25:"},
+		{"no negative zero", weather.LatLong{Latitude: -0.1, Longitude: -0.05}, "weather:0.00,0.00:"},
+		{"poles and antimeridian", weather.LatLong{Latitude: 90, Longitude: -180}, "weather:90.00,-180.00:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := weatherCachePrefix(tt.latLong); got != tt.want {
+				t.Errorf("weatherCachePrefix(%v) = %q, want %q", tt.latLong, got, tt.want)
+			}
+		})
+	}
+}
+
+// weatherCacheTable is a database/sql driver holding just the weather_cache
+// table, enough to run dbCache's Get, Set and Delete without Postgres. It
+// recognizes the statements by their first word.
+type weatherCacheTable struct {
+	mu   sync.Mutex
+	rows map[string]CacheEntry
+}
+
+func newTestDBCache(table *weatherCacheTable, prefix string) *dbCache {
+	db := &timeoutDB{DB: sqlx.NewDb(sql.OpenDB(table), "postgres")}
+	return newDBCache(db, db, prefix)
+}
+
+func (t *weatherCacheTable) Connect(context.Context) (driver.Conn, error) { return t, nil }
+func (t *weatherCacheTable) Driver() driver.Driver                        { return nil }
+func (t *weatherCacheTable) Begin() (driver.Tx, error)                    { return nil, errors.ErrUnsupported }
+func (t *weatherCacheTable) Close() error                                 { return nil }
+
+func (t *weatherCacheTable) Prepare(query string) (driver.Stmt, error) {
+	return weatherCacheStmt{t, strings.Fields(query)[0]}, nil
+}
+
+// keys returns the stored keys in order.
+func (t *weatherCacheTable) keys() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	keys := make([]string, 0, len(t.rows))
+	for key := range t.rows {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+type weatherCacheStmt struct {
+	table *weatherCacheTable
+	verb  string
+}
+
+func (s weatherCacheStmt) Close() error  { return nil }
+func (s weatherCacheStmt) NumInput() int { return -1 }
+
+func (s weatherCacheStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.table.mu.Lock()
+	defer s.table.mu.Unlock()
+	key := args[0].(string)
+	switch s.verb {
+	case "INSERT":
+		s.table.rows[key] = CacheEntry{Value: args[1].([]byte), FetchedAt: args[2].(time.Time), ExpiresAt: args[3].(time.Time)}
+		return driver.RowsAffected(1), nil
+	case "DELETE":
+		_, ok := s.table.rows[key]
+		delete(s.table.rows, key)
+		if ok {
+			return driver.RowsAffected(1), nil
+		}
+		return driver.RowsAffected(0), nil
+	}
+	return nil, fmt.Errorf("unsupported statement %s", s.verb)
+}
+
+func (s weatherCacheStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.table.mu.Lock()
+	defer s.table.mu.Unlock()
+	rows := &weatherCacheRows{}
+	if entry, ok := s.table.rows[args[0].(string)]; ok {
+		rows.values = [][]driver.Value{{entry.Value, entry.FetchedAt, entry.ExpiresAt}}
+	}
+	return rows, nil
+}
+
+type weatherCacheRows struct {
+	values [][]driver.Value
+}
+
+func (r *weatherCacheRows) Columns() []string { return []string{"value", "fetched_at", "expires_at"} }
+func (r *weatherCacheRows) Close() error      { return nil }
+
+func (r *weatherCacheRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+func TestDBCachePrefix(t *testing.T) {
+	berlin := weatherCacheKey(weather.LatLong{Latitude: 52.52, Longitude: 13.41}, weather.ForecastParams{})
+	table := &weatherCacheTable{rows: make(map[string]CacheEntry)}
+	staging, prod, unprefixed := newTestDBCache(table, "staging:"), newTestDBCache(table, "prod:"), newTestDBCache(table, "")
+
+	if err := staging.Set(berlin, []byte(testForecast), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := prod.Get(berlin); !errors.Is(err, ErrCacheMiss) {
+		t.Errorf("prod read staging's entry: got error %v, want ErrCacheMiss", err)
+	}
+	if entry, err := staging.Get(berlin); err != nil || string(entry.Value) != testForecast {
+		t.Errorf("staging: got %q, error %v; want its own entry", entry.Value, err)
+	}
+	if deleted, err := prod.Delete(berlin); err != nil || deleted {
+		t.Errorf("prod deleted staging's entry: got %t, error %v", deleted, err)
+	}
+
+	// The empty default keeps keys as they were before CACHE_PREFIX.
+	if err := unprefixed.Set(berlin, []byte(testForecast), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"staging:" + berlin, berlin}
+	if got := table.keys(); !slices.Equal(got, want) {
+		t.Errorf("got keys %q, want %q", got, want)
+	}
+	if _, err := unprefixed.Get(berlin); err != nil {
+		t.Errorf("unprefixed: got error %v, want its entry", err)
+	}
+}
+
+func TestDBCacheReplica(t *testing.T) {
+	primary := &weatherCacheTable{rows: make(map[string]CacheEntry)}
+	replica := &weatherCacheTable{rows: make(map[string]CacheEntry)}
+	cache := newDBCache(&timeoutDB{DB: sqlx.NewDb(sql.OpenDB(primary), "postgres")},
+		&timeoutDB{DB: sqlx.NewDb(sql.OpenDB(replica), "postgres")}, "")
+
+	if err := cache.Set("weather:52.50,13.50:", []byte(testForecast), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if len(primary.keys()) != 1 || len(replica.keys()) != 0 {
+		t.Errorf("Set wrote %q to the primary and %q to the replica, want the primary only", primary.keys(), replica.keys())
+	}
+	if _, err := cache.Get("weather:52.50,13.50:"); !errors.Is(err, ErrCacheMiss) {
+		t.Errorf("Get before replication: got error %v, want ErrCacheMiss from the replica", err)
+	}
+	replica.rows["weather:52.50,13.50:"] = primary.rows["weather:52.50,13.50:"]
+	if entry, err := cache.Get("weather:52.50,13.50:"); err != nil || string(entry.Value) != testForecast {
+		t.Errorf("Get after replication: got %q, error %v", entry.Value, err)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mre/goforecast/internal/weather"
+)
+
+// dbDriver is the database/sql driver connectDB connects with.
+var dbDriver = "postgres"
+
+// connectDB connects to Postgres, retrying with exponential backoff until the
+// database accepts connections or timeout elapses. Orchestrated deploys often
+// start the server before the database is ready, so failing on the first
+// attempt is too eager.
+func connectDB(dsn string, timeout time.Duration) (*sqlx.DB, error) {
+	deadline := time.Now().Add(timeout)
+	backoff := 250 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		db, err := sqlx.Connect(dbDriver, dsn)
+		if err == nil {
+			return db, nil
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return nil, fmt.Errorf("database unreachable after %d attempts: %w", attempt, err)
+		}
+		slog.Warn("database not ready, retrying", "attempt", attempt, "backoff", backoff, "error", err)
+		time.Sleep(backoff)
+		backoff = min(2*backoff, 5*time.Second)
+	}
+}
+
+// withStatementTimeout adds a statement_timeout run-time parameter to dsn,
+// so Postgres itself aborts queries that run longer than timeout. lib/pq
+// passes parameters it doesn't know on to the server, in both URL and
+// key=value connection strings.
+func withStatementTimeout(dsn string, timeout time.Duration) string {
+	if timeout <= 0 {
+		return dsn
+	}
+	ms := strconv.FormatInt(timeout.Milliseconds(), 10)
+	if u, err := url.Parse(dsn); err == nil && strings.Contains(dsn, "://") {
+		query := u.Query()
+		query.Set("statement_timeout", ms)
+		u.RawQuery = query.Encode()
+		return u.String()
+	}
+	return dsn + " statement_timeout=" + ms
+}
+
+// timeoutDB runs every query with a context deadline, so a slow query is
+// cancelled on our side and its connection returned to the pool even if
+// the server-side statement_timeout doesn't fire.
+type timeoutDB struct {
+	*sqlx.DB
+	timeout time.Duration
+}
+
+func (db *timeoutDB) context() (context.Context, context.CancelFunc) {
+	if db.timeout <= 0 {
+		return context.Background(), func() {}
+	}
+	return context.WithTimeout(context.Background(), db.timeout)
+}
+
+func (db *timeoutDB) Get(dest any, query string, args ...any) error {
+	ctx, cancel := db.context()
+	defer cancel()
+	return db.DB.GetContext(ctx, dest, query, args...)
+}
+
+func (db *timeoutDB) Select(dest any, query string, args ...any) error {
+	ctx, cancel := db.context()
+	defer cancel()
+	return db.DB.SelectContext(ctx, dest, query, args...)
+}
+
+func (db *timeoutDB) Exec(query string, args ...any) (sql.Result, error) {
+	ctx, cancel := db.context()
+	defer cancel()
+	return db.DB.ExecContext(ctx, query, args...)
+}
+
+// cityRepo is the cities table. Writes go to the primary database; reads
+// that can tolerate replication lag go to the replica if there is one.
+type cityRepo struct {
+	primary *timeoutDB
+	replica *timeoutDB
+}
+
+// newCityRepo returns a repo reading from replica, or from primary if
+// replica is nil.
+func newCityRepo(primary, replica *timeoutDB) *cityRepo {
+	if replica == nil {
+		replica = primary
+	}
+	return &cityRepo{primary: primary, replica: replica}
+}
+
+// find returns the newest row for name. It reads from the primary: a lagging
+// replica would miss a city stored moments ago and have it inserted twice.
+func (r *cityRepo) find(name string) (storedCity, error) {
+	var city storedCity
+	err := r.primary.Get(&city, `SELECT lat AS latitude, long AS longitude, place_name AS name, admin1, country, geocoded_at
+		FROM cities WHERE name = $1 ORDER BY id DESC LIMIT 1`, name)
+	return city, err
+}
+
+// last returns the names of the ten most recently added cities.
+func (r *cityRepo) last() ([]string, error) {
+	var cities []string
+	err := r.replica.Select(&cities, "SELECT name FROM cities ORDER BY id DESC LIMIT 10")
+	if err != nil {
+		return nil, err
+	}
+	return cities, nil
+}
+
+func (r *cityRepo) insert(name string, place weather.Place) error {
+	_, err := r.primary.Exec("INSERT INTO cities (name, lat, long, place_name, admin1, country) VALUES ($1, $2, $3, $4, $5, $6)",
+		name, place.Latitude, place.Longitude, place.Name, place.Admin1, place.Country)
+	return err
+}
+
+// update stores a freshly geocoded place for an existing city.
+func (r *cityRepo) update(name string, place weather.Place) error {
+	_, err := r.primary.Exec(`UPDATE cities SET lat = $2, long = $3, place_name = $4, admin1 = $5, country = $6,
+		geocoded_at = now(), last_requested_at = now() WHERE name = $1`,
+		name, place.Latitude, place.Longitude, place.Name, place.Admin1, place.Country)
+	return err
+}
+
+// delete removes every row for name from the cities table together with
+// all cached forecasts for its coordinates, whatever parameters they were
+// requested with, and reports how many of each were removed.
+func (r *cityRepo) delete(cache Cache, name string) (cities, forecasts int64, err error) {
+	var coords []weather.LatLong
+	err = r.primary.Select(&coords, "SELECT lat AS latitude, long AS longitude FROM cities WHERE name = $1", name)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, latLong := range coords {
+		deleted, err := cache.DeletePrefix(weatherCachePrefix(latLong))
+		if err != nil {
+			return 0, forecasts, err
+		}
+		forecasts += deleted
+	}
+
+	res, err := r.primary.Exec("DELETE FROM cities WHERE name = $1", name)
+	if err != nil {
+		return 0, forecasts, err
+	}
+	cities, err = res.RowsAffected()
+	return cities, forecasts, err
+}
+
+// touch records that name was just requested.
+func (r *cityRepo) touch(name string) error {
+	_, err := r.primary.Exec("UPDATE cities SET last_requested_at = now() WHERE name = $1", name)
+	return err
+}
+
+// prune deletes the least recently requested cities so that at most max
+// remain, and reports how many were deleted.
+func (r *cityRepo) prune(max int) (int64, error) {
+	res, err := r.primary.Exec(`DELETE FROM cities WHERE id IN (
+		SELECT id FROM cities ORDER BY last_requested_at DESC, id DESC OFFSET $1
+	)`, max)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// aliases returns the city_aliases table, mapping lower-case aliases to the
+// city names they stand for.
+func (r *cityRepo) aliases() (map[string]string, error) {
+	var rows []struct {
+		Alias string `db:"alias"`
+		City  string `db:"city"`
+	}
+	if err := r.replica.Select(&rows, "SELECT alias, city FROM city_aliases"); err != nil {
+		return nil, err
+	}
+	aliases := make(map[string]string, len(rows))
+	for _, row := range rows {
+		aliases[strings.ToLower(row.Alias)] = row.City
+	}
+	return aliases, nil
+}
+
+// cachedCity is a row of the cities table as shown to admins.
+type cachedCity struct {
+	Name            string    `db:"name" json:"name"`
+	Latitude        float64   `db:"latitude" json:"latitude"`
+	Longitude       float64   `db:"longitude" json:"longitude"`
+	LastRequestedAt time.Time `db:"last_requested_at" json:"last_requested_at"`
+}
+
+// likeEscaper escapes the characters ILIKE treats specially, so a search for
+// "50%" matches literally.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// search returns up to limit cities whose name starts with prefix, ignoring
+// case, most recently requested first.
+func (r *cityRepo) search(prefix string, limit int) ([]cachedCity, error) {
+	cities := []cachedCity{}
+	err := r.replica.Select(&cities, `SELECT name, lat AS latitude, long AS longitude, last_requested_at
+		FROM cities WHERE name ILIKE $1 ORDER BY last_requested_at DESC LIMIT $2`,
+		likeEscaper.Replace(prefix)+"%", limit)
+	return cities, err
+}
+
+// all returns every cached city, most recently requested first.
+func (r *cityRepo) all() ([]cachedCity, error) {
+	cities := []cachedCity{}
+	err := r.replica.Select(&cities, `SELECT name, lat AS latitude, long AS longitude, last_requested_at
+		FROM cities ORDER BY last_requested_at DESC`)
+	return cities, err
+}
+
+// earthRadiusKm is the mean radius used for great-circle distances.
+const earthRadiusKm = 6371.0
+
+// nearbyCity is a cached city together with its distance from a point.
+type nearbyCity struct {
+	cachedCity
+	DistanceKm float64 `db:"distance_km" json:"distance_km"`
+}
+
+// nearest returns the cached city closest to latLong by haversine distance,
+// or sql.ErrNoRows if there are none. The distance is computed in the query
+// so only one row comes back, however many cities are cached.
+func (r *cityRepo) nearest(latLong weather.LatLong) (nearbyCity, error) {
+	var city nearbyCity
+	err := r.replica.Get(&city, `SELECT name, lat AS latitude, long AS longitude, last_requested_at,
+			2 * $3::float8 * asin(least(1, sqrt(
+				power(sin(radians(lat - $1) / 2), 2) +
+				cos(radians($1)) * cos(radians(lat)) * power(sin(radians(long - $2) / 2), 2)
+			))) AS distance_km
+		FROM cities ORDER BY distance_km LIMIT 1`,
+		latLong.Latitude, latLong.Longitude, earthRadiusKm)
+	return city, err
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mre/goforecast/internal/weather"
+)
+
+// citiesTable is a database/sql driver holding a cities table of names and
+// coordinates, enough to store, find, list and delete cities without
+// Postgres. It recognizes the statements by how they start.
+type citiesTable struct {
+	mu   sync.Mutex
+	rows []cityRow
+}
+
+type cityRow struct {
+	name string
+	weather.LatLong
+}
+
+func newTestDB(table *citiesTable) *timeoutDB {
+	return &timeoutDB{DB: sqlx.NewDb(sql.OpenDB(table), "postgres")}
+}
+
+func (t *citiesTable) Connect(context.Context) (driver.Conn, error) { return t, nil }
+func (t *citiesTable) Driver() driver.Driver                        { return nil }
+func (t *citiesTable) Begin() (driver.Tx, error)                    { return nil, errors.ErrUnsupported }
+func (t *citiesTable) Close() error                                 { return nil }
+
+func (t *citiesTable) Prepare(query string) (driver.Stmt, error) {
+	return citiesStmt{t, query}, nil
+}
+
+// names returns the names of the stored cities.
+func (t *citiesTable) names() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	names := make([]string, len(t.rows))
+	for i, row := range t.rows {
+		names[i] = row.name
+	}
+	return names
+}
+
+type citiesStmt struct {
+	table *citiesTable
+	query string
+}
+
+func (s citiesStmt) Close() error  { return nil }
+func (s citiesStmt) NumInput() int { return -1 }
+
+func (s citiesStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.table.mu.Lock()
+	defer s.table.mu.Unlock()
+	switch {
+	case strings.HasPrefix(s.query, "INSERT INTO cities"):
+		row := cityRow{args[0].(string), weather.LatLong{Latitude: args[1].(float64), Longitude: args[2].(float64)}}
+		s.table.rows = append(s.table.rows, row)
+		return driver.RowsAffected(1), nil
+	case strings.HasPrefix(s.query, "DELETE FROM cities WHERE name = $1"):
+		before := len(s.table.rows)
+		s.table.rows = slices.DeleteFunc(s.table.rows, func(row cityRow) bool { return row.name == args[0] })
+		return driver.RowsAffected(before - len(s.table.rows)), nil
+	}
+	return nil, fmt.Errorf("unsupported statement %q", s.query)
+}
+
+func (s citiesStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.table.mu.Lock()
+	defer s.table.mu.Unlock()
+	switch {
+	case strings.HasPrefix(s.query, "SELECT name FROM cities"):
+		rows := &tableRows{columns: []string{"name"}}
+		for _, row := range s.table.rows {
+			rows.values = append(rows.values, []driver.Value{row.name})
+		}
+		return rows, nil
+	case strings.HasPrefix(s.query, "SELECT lat AS latitude, long AS longitude, place_name AS name"):
+		rows := &tableRows{columns: []string{"latitude", "longitude", "name", "admin1", "country", "geocoded_at"}}
+		for i := len(s.table.rows) - 1; i >= 0; i-- {
+			if row := s.table.rows[i]; row.name == args[0] {
+				rows.values = append(rows.values, []driver.Value{row.Latitude, row.Longitude, row.name, "", "", time.Time{}})
+				break
+			}
+		}
+		return rows, nil
+	case strings.HasPrefix(s.query, "SELECT lat AS latitude, long AS longitude FROM cities WHERE name = $1"):
+		rows := &tableRows{columns: []string{"latitude", "longitude"}}
+		for _, row := range s.table.rows {
+			if row.name == args[0] {
+				rows.values = append(rows.values, []driver.Value{row.Latitude, row.Longitude})
+			}
+		}
+		return rows, nil
+	}
+	return nil, fmt.Errorf("unsupported query %q", s.query)
+}
+
+type tableRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *tableRows) Columns() []string { return r.columns }
+func (r *tableRows) Close() error      { return nil }
+
+func (r *tableRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+// startingDB is a database/sql driver for a database that refuses
+// connections until it has been asked a given number of times, like
+// Postgres still starting up next to the server.
+type startingDB struct {
+	mu       sync.Mutex
+	attempts int
+	readyAt  int
+}
+
+func (d *startingDB) Open(string) (driver.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.attempts++
+	if d.attempts < d.readyAt {
+		return nil, errors.New("connection refused")
+	}
+	return &citiesTable{}, nil
+}
+
+var startingPostgres = &startingDB{}
+
+func init() {
+	sql.Register("starting-postgres", startingPostgres)
+}
+
+func TestConnectDBRetries(t *testing.T) {
+	dbDriver = "starting-postgres"
+	t.Cleanup(func() { dbDriver = "postgres" })
+
+	*startingPostgres = startingDB{readyAt: 3}
+	db, err := connectDB("postgres://db/forecast", 10*time.Second)
+	if err != nil {
+		t.Fatalf("connectDB: %v", err)
+	}
+	db.Close()
+	if startingPostgres.attempts != 3 {
+		t.Errorf("connected after %d attempts, want 3", startingPostgres.attempts)
+	}
+
+	*startingPostgres = startingDB{readyAt: 100}
+	start := time.Now()
+	if _, err := connectDB("postgres://db/forecast", 100*time.Millisecond); err == nil {
+		t.Fatal("connectDB succeeded against a database that never came up")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("gave up after %s, want about the 100ms timeout", elapsed)
+	}
+}
+
+func TestCityRepoReplica(t *testing.T) {
+	berlin := weather.Place{LatLong: weather.LatLong{Latitude: 52.52, Longitude: 13.41}, Name: "Berlin"}
+	primary := &citiesTable{}
+	// The replica lags behind: it doesn't have Berlin yet but still has a
+	// city since deleted from the primary.
+	replica := &citiesTable{rows: []cityRow{{name: "Atlantis"}}}
+	repo := newCityRepo(newTestDB(primary), newTestDB(replica))
+
+	if err := repo.insert("Berlin", berlin); err != nil {
+		t.Fatal(err)
+	}
+	if got := primary.names(); !slices.Equal(got, []string{"Berlin"}) {
+		t.Errorf("primary has %q, want the inserted city", got)
+	}
+	if got := replica.names(); !slices.Equal(got, []string{"Atlantis"}) {
+		t.Errorf("insert reached the replica: %q", got)
+	}
+	// find must see a city stored moments ago, so it reads the primary.
+	if city, err := repo.find("Berlin"); err != nil || city.LatLong != berlin.LatLong {
+		t.Errorf("find: got %+v, error %v; want Berlin from the primary", city, err)
+	}
+	if last, err := repo.last(); err != nil || !slices.Equal(last, []string{"Atlantis"}) {
+		t.Errorf("last: got %q, error %v; want the replica's cities", last, err)
+	}
+
+	withoutReplica := newCityRepo(newTestDB(primary), nil)
+	if last, err := withoutReplica.last(); err != nil || !slices.Equal(last, []string{"Berlin"}) {
+		t.Errorf("without a replica: got %q, error %v; want the primary's cities", last, err)
+	}
+}
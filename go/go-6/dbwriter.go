@@ -0,0 +1,64 @@
+package main
+
+import (
+	"expvar"
+	"log/slog"
+)
+
+var (
+	dbWriteQueueDepth = expvar.NewInt("db_write_queue_depth")
+	dbWritesDropped   = expvar.NewInt("db_writes_dropped_total")
+)
+
+// dbWriter runs city writes on a few dedicated workers, so a burst of new
+// cities can't take every pooled connection away from reads. Writes that
+// don't fit in the queue are dropped rather than making the request wait;
+// the cities table is only a cache of geocoding results, so losing a write
+// costs at most another lookup.
+type dbWriter struct {
+	jobs chan dbWrite
+	// pending counts queued and running writes, so shutdown can wait for
+	// them.
+	pending *backgroundTasks
+}
+
+type dbWrite struct {
+	what string
+	fn   func() error
+}
+
+// newDBWriter starts workers goroutines that run queued writes, with room
+// for size more to wait. Writes are tracked in pending.
+func newDBWriter(workers, size int, pending *backgroundTasks) *dbWriter {
+	w := &dbWriter{jobs: make(chan dbWrite, size), pending: pending}
+	for i := 0; i < workers; i++ {
+		go w.run()
+	}
+	return w
+}
+
+func (w *dbWriter) run() {
+	for job := range w.jobs {
+		dbWriteQueueDepth.Add(-1)
+		if err := job.fn(); err != nil {
+			slog.Warn("database write failed", "write", job.what, "error", err)
+		}
+		w.pending.Done()
+	}
+}
+
+// Submit queues fn, described by what in logs, or drops it with a warning if
+// the queue is full. It reports whether fn was queued.
+func (w *dbWriter) Submit(what string, fn func() error) bool {
+	w.pending.Add(1)
+	select {
+	case w.jobs <- dbWrite{what: what, fn: fn}:
+		dbWriteQueueDepth.Add(1)
+		return true
+	default:
+		w.pending.Done()
+		dbWritesDropped.Add(1)
+		slog.Warn("database write queue full, dropping write", "write", what)
+		return false
+	}
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/mre/goforecast/internal/weather"
+)
+
+// DaySummary aggregates the hourly forecasts of a single calendar day. Days at
+// the edges of the forecast window may be partial, which Hours makes visible.
+type DaySummary struct {
+	Date  time.Time
+	Hours int
+	Min   float64
+	Max   float64
+	Avg   float64
+	// Precipitation is the total over the day's hours, in mm. MaxWind is the
+	// highest wind speed, in km/h, or nil if no hour reported one.
+	Precipitation float64
+	MaxWind       *float64
+}
+
+type DailyDisplay struct {
+	City   string
+	Place  string
+	Days   []DaySummary
+	Format weather.TemperatureFormat
+}
+
+// smoothForecasts computes an exponential moving average over the hourly
+// temperatures and stores it in each forecast's SmoothedTemperature. alpha is
+// the weight given to the newest reading and must be in (0, 1]. Hours without
+// a temperature are skipped and show the same placeholder smoothed.
+func smoothForecasts(forecasts []weather.Forecast, alpha float64, format weather.TemperatureFormat) error {
+	if !(alpha > 0 && alpha <= 1) {
+		return fmt.Errorf("smoothing factor must be in (0, 1], got %v", alpha)
+	}
+
+	var ema float64
+	started := false
+	for i := range forecasts {
+		switch {
+		case forecasts[i].Missing:
+			forecasts[i].SmoothedTemperature = forecasts[i].Temperature
+			continue
+		case !started:
+			ema, started = forecasts[i].Celsius, true
+		default:
+			ema = alpha*forecasts[i].Celsius + (1-alpha)*ema
+		}
+		forecasts[i].SmoothedTemperature = format.Format(ema)
+	}
+	return nil
+}
+
+// aggregateDaily groups hourly forecasts by calendar date and computes the
+// minimum, maximum and mean temperature of each day, in chronological order.
+// Hours without a temperature don't count.
+func aggregateDaily(forecasts []weather.Forecast) []DaySummary {
+	var days []DaySummary
+	index := make(map[time.Time]int)
+	for _, f := range forecasts {
+		if f.Missing {
+			continue
+		}
+		date := time.Date(f.Time.Year(), f.Time.Month(), f.Time.Day(), 0, 0, 0, 0, f.Time.Location())
+		i, ok := index[date]
+		if !ok {
+			i = len(days)
+			index[date] = i
+			days = append(days, DaySummary{Date: date, Min: f.Celsius, Max: f.Celsius})
+		}
+		day := &days[i]
+		day.Hours++
+		day.Min = min(day.Min, f.Celsius)
+		day.Max = max(day.Max, f.Celsius)
+		day.Avg += f.Celsius
+		if f.PrecipitationMM != nil {
+			day.Precipitation += *f.PrecipitationMM
+		}
+		if f.WindKmh != nil && (day.MaxWind == nil || *f.WindKmh > *day.MaxWind) {
+			day.MaxWind = f.WindKmh
+		}
+	}
+
+	for i := range days {
+		days[i].Avg /= float64(days[i].Hours)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Date.Before(days[j].Date) })
+	return days
+}
+
+// forecastLayout is how open-meteo formats its local times, which is also what
+// we accept for absolute time ranges.
+const forecastLayout = "2006-01-02T15:04"
+
+// timeWindow selects forecasts either between two local timestamps or, when
+// given as HH:MM, between two times of day on every day. Both ends are
+// inclusive and either may be left open.
+type timeWindow struct {
+	clock      bool
+	from, to   time.Time
+	fromMinute int
+	toMinute   int
+}
+
+func parseTimeWindow(from, to string) (timeWindow, error) {
+	var w timeWindow
+	fromClock, toClock := isClockTime(from), isClockTime(to)
+	if from != "" && to != "" && fromClock != toClock {
+		return w, fmt.Errorf("from and to must both be HH:MM or both be timestamps")
+	}
+	w.clock = fromClock || toClock
+	w.toMinute = 24*60 - 1
+
+	for _, end := range []struct {
+		value  string
+		t      *time.Time
+		minute *int
+	}{{from, &w.from, &w.fromMinute}, {to, &w.to, &w.toMinute}} {
+		if end.value == "" {
+			continue
+		}
+		if w.clock {
+			t, err := time.Parse("15:04", end.value)
+			if err != nil {
+				return w, err
+			}
+			*end.minute = t.Hour()*60 + t.Minute()
+			continue
+		}
+		t, err := time.Parse(forecastLayout, end.value)
+		if err != nil {
+			return w, err
+		}
+		*end.t = t
+	}
+	return w, nil
+}
+
+func isClockTime(s string) bool {
+	_, err := time.Parse("15:04", s)
+	return err == nil
+}
+
+func (w timeWindow) contains(t time.Time) bool {
+	if !w.clock {
+		// from and to are wall-clock times at the forecast location, so
+		// compare them against t's wall clock rather than the instant.
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC)
+		return (w.from.IsZero() || !t.Before(w.from)) && (w.to.IsZero() || !t.After(w.to))
+	}
+	minute := t.Hour()*60 + t.Minute()
+	if w.fromMinute <= w.toMinute {
+		return minute >= w.fromMinute && minute <= w.toMinute
+	}
+	// The window wraps around midnight, e.g. 22:00 to 06:00.
+	return minute >= w.fromMinute || minute <= w.toMinute
+}
+
+// filterForecasts keeps the forecasts whose time falls inside w.
+func filterForecasts(forecasts []weather.Forecast, w timeWindow) []weather.Forecast {
+	filtered := make([]weather.Forecast, 0, len(forecasts))
+	for _, f := range forecasts {
+		if w.contains(f.Time) {
+			filtered = append(filtered, f)
+		}
+	}
+	return filtered
+}
+
+// inTimezone rewrites each forecast's time into loc and replaces the display
+// date with an ISO 8601 timestamp, for clients that need an unambiguous time
+// rather than a friendly label. A nil loc keeps the location's own timezone.
+func inTimezone(forecasts []weather.Forecast, loc *time.Location) {
+	for i := range forecasts {
+		if loc != nil {
+			forecasts[i].Time = forecasts[i].Time.In(loc)
+		}
+		forecasts[i].Date = forecasts[i].Time.Format(time.RFC3339)
+	}
+}
+
+// forecastSeries turns a forecast into parallel arrays, the shape charting
+// libraries such as Chart.js expect: "labels" holds the times and every other
+// array has one value per label. Values are taken as open-meteo reported them,
+// in the units listed under "units". Variables missing from the response are
+// left out.
+func forecastSeries(resp *weather.WeatherResponse, maxEntries int) (map[string]any, error) {
+	hourly := resp.Hourly
+	if len(hourly.Time) == 0 {
+		return nil, weather.ErrNoForecastData
+	}
+	n := min(len(hourly.Time), maxEntries)
+
+	series := map[string]any{"labels": hourly.Time[:n]}
+	units := map[string]string{}
+	addSeries(series, units, "temperature", resp.HourlyUnits.Temperature2m, hourly.Temperature2m, n)
+	addSeries(series, units, "pressure", resp.HourlyUnits.SurfacePressure, hourly.SurfacePressure, n)
+	addSeries(series, units, "precipitation", resp.HourlyUnits.Precipitation, hourly.Precipitation, n)
+	addSeries(series, units, "snowfall", resp.HourlyUnits.Snowfall, hourly.Snowfall, n)
+	addSeries(series, units, "wind_speed", resp.HourlyUnits.WindSpeed10m, hourly.WindSpeed10m, n)
+	series["units"] = units
+	return series, nil
+}
+
+// addSeries adds the first n values under name. Padding would misalign the
+// chart, so variables that don't cover every hour are left out.
+func addSeries[T any](series map[string]any, units map[string]string, name, unit string, values []T, n int) {
+	if len(values) < n {
+		return
+	}
+	series[name] = values[:n]
+	units[name] = unit
+}
+
+// ErrTooFewForecasts is returned when there aren't enough forecasts left to
+// compute a trend from.
+var ErrTooFewForecasts = errors.New("not enough upcoming forecasts to compute a trend")
+
+// steadyThreshold is the temperature change per hour, in °C, below which the
+// trend counts as steady.
+const steadyThreshold = 0.2
+
+// trend fits a least-squares line through the hourly temperatures and reports
+// whether they are "rising", "falling" or "steady", along with the slope in
+// °C per hour. Forecasts are assumed to be one hour apart; hours without a
+// temperature are left out of the fit.
+func trend(forecasts []weather.Forecast) (string, float64, error) {
+	var n, sumX, sumY, sumXY, sumXX float64
+	for i, f := range forecasts {
+		if f.Missing {
+			continue
+		}
+		n++
+		x := float64(i)
+		sumX += x
+		sumY += f.Celsius
+		sumXY += x * f.Celsius
+		sumXX += x * x
+	}
+	if n < 2 {
+		return "", 0, ErrTooFewForecasts
+	}
+	slope := (n*sumXY - sumX*sumY) / (n*sumXX - sumX*sumX)
+	switch {
+	case slope >= steadyThreshold:
+		return "rising", slope, nil
+	case slope <= -steadyThreshold:
+		return "falling", slope, nil
+	default:
+		return "steady", slope, nil
+	}
+}
+
+// upcoming returns up to hours forecasts starting with the current hour.
+func upcoming(forecasts []weather.Forecast, now time.Time, hours int) []weather.Forecast {
+	start := now.Truncate(time.Hour)
+	for i, f := range forecasts {
+		if !f.Time.Before(start) {
+			return forecasts[i:min(len(forecasts), i+hours)]
+		}
+	}
+	return nil
+}
+
+// extremes returns the warmest and coldest forecasts. Ties go to the earlier
+// hour; a single forecast is both, and an empty slice, or one without any
+// temperatures, yields zero values.
+func extremes(forecasts []weather.Forecast) (warmest, coldest weather.Forecast) {
+	found := false
+	for _, f := range forecasts {
+		if f.Missing {
+			continue
+		}
+		if !found || f.Celsius > warmest.Celsius {
+			warmest = f
+		}
+		if !found || f.Celsius < coldest.Celsius {
+			coldest = f
+		}
+		found = true
+	}
+	return warmest, coldest
+}
+
+// defaultDegreeDayBase is the usual base temperature for degree-days, in °C.
+const defaultDegreeDayBase = 18.0
+
+// degreeDays sums, over the daily mean temperatures, how far each day falls
+// below baseTemp (heating) and rises above it (cooling), in °C-days. Partial
+// days at the edges of the forecast count like full ones.
+func degreeDays(forecasts []weather.Forecast, baseTemp float64) (heating, cooling float64) {
+	for _, day := range aggregateDaily(forecasts) {
+		heating += max(0, baseTemp-day.Avg)
+		cooling += max(0, day.Avg-baseTemp)
+	}
+	return heating, cooling
+}
+
+// picnicThresholds are the limits of good weather: a mean temperature
+// between MinCelsius and MaxCelsius, at most MaxPrecipitationMM of rain and
+// wind no faster than MaxWindKmh.
+type picnicThresholds struct {
+	MinCelsius         float64
+	MaxCelsius         float64
+	MaxPrecipitationMM float64
+	MaxWindKmh         float64
+}
+
+// isGoodWeather checks day against thresholds and returns the share of
+// checks it passes as a score from 0 to 100, with a reason for each one it
+// fails. The day is good if there are no reasons. Wind is only checked for
+// days that have wind data.
+func isGoodWeather(day DaySummary, thresholds picnicThresholds) (int, []string) {
+	var reasons []string
+	checks := 2
+	if day.Avg < thresholds.MinCelsius {
+		reasons = append(reasons, "too cold")
+	}
+	if day.Avg > thresholds.MaxCelsius {
+		reasons = append(reasons, "too hot")
+	}
+	if day.Precipitation > thresholds.MaxPrecipitationMM {
+		reasons = append(reasons, "rainy")
+	}
+	if day.MaxWind != nil {
+		checks++
+		if *day.MaxWind > thresholds.MaxWindKmh {
+			reasons = append(reasons, "too windy")
+		}
+	}
+	return 100 * (checks - len(reasons)) / checks, reasons
+}
+
+// temperatureAlerts returns an alert for every forecast colder than below or
+// warmer than above, in order. Thresholds are in Celsius; nil disables one.
+func temperatureAlerts(forecasts []weather.Forecast, below, above *float64, format weather.TemperatureFormat) []weather.Alert {
+	var alerts []weather.Alert
+	for _, f := range forecasts {
+		if f.Missing {
+			continue
+		}
+		for _, check := range []struct {
+			kind      string
+			threshold *float64
+			breached  func(celsius, threshold float64) bool
+		}{
+			{"below", below, func(c, t float64) bool { return c < t }},
+			{"above", above, func(c, t float64) bool { return c > t }},
+		} {
+			if check.threshold == nil || !check.breached(f.Celsius, *check.threshold) {
+				continue
+			}
+			alerts = append(alerts, weather.Alert{
+				Time:        f.Time,
+				Date:        f.Date,
+				Kind:        check.kind,
+				Temperature: f.Temperature,
+				Threshold:   format.Format(*check.threshold),
+			})
+		}
+	}
+	return alerts
+}
+
+// mergeForecasts combines two possibly overlapping runs of hourly forecasts,
+// e.g. a cached one and a fresher incremental fetch, into one sorted by time.
+// Where both have an entry for the same instant, b's is kept, so b should be
+// the newer source.
+func mergeForecasts(a, b []weather.Forecast) []weather.Forecast {
+	merged := make([]weather.Forecast, 0, len(a)+len(b))
+	seen := make(map[int64]int, len(a)+len(b))
+	for _, source := range [][]weather.Forecast{a, b} {
+		for _, f := range source {
+			at := f.Time.UnixNano()
+			if i, ok := seen[at]; ok {
+				merged[i] = f
+				continue
+			}
+			seen[at] = len(merged)
+			merged = append(merged, f)
+		}
+	}
+	sort.SliceStable(merged, func(i, j int) bool { return merged[i].Time.Before(merged[j].Time) })
+	return merged
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mre/goforecast/internal/weather"
+)
+
+func TestTrend(t *testing.T) {
+	nan := math.NaN()
+	tests := []struct {
+		name         string
+		temperatures []float64
+		want         string
+		wantSlope    float64
+		wantErr      error
+	}{
+		{"rising", []float64{10, 11, 12, 13}, "rising", 1, nil},
+		{"falling", []float64{20, 19.5, 19, 18.5}, "falling", -0.5, nil},
+		{"steady", []float64{15, 15.1, 15, 15.1}, "steady", 0.02, nil},
+		{"just above steady", []float64{10, 10.25}, "rising", 0.25, nil},
+		{"just below steady", []float64{10, 9.875}, "steady", -0.125, nil},
+		{"skips missing hours", []float64{10, nan, 12, nan, 14}, "rising", 1, nil},
+		{"two readings", []float64{10, 8}, "falling", -2, nil},
+		{"one reading", []float64{10}, "", 0, ErrTooFewForecasts},
+		{"all missing", []float64{nan, nan, nan}, "", 0, ErrTooFewForecasts},
+		{"empty", nil, "", 0, ErrTooFewForecasts},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			direction, slope, err := trend(hours(day, tt.temperatures...))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if direction != tt.want || math.Abs(slope-tt.wantSlope) > 1e-9 {
+				t.Errorf("got %q with slope %v, want %q with slope %v", direction, slope, tt.want, tt.wantSlope)
+			}
+		})
+	}
+}
+
+func TestUpcoming(t *testing.T) {
+	forecasts := hours(day, 1, 2, 3, 4, 5)
+	tests := []struct {
+		name  string
+		now   time.Time
+		hours int
+		want  []float64
+	}{
+		{"from the start", day, 3, []float64{1, 2, 3}},
+		{"within the current hour", hour(1).Add(59 * time.Minute), 2, []float64{2, 3}},
+		{"before the forecast", day.Add(-5 * time.Hour), 2, []float64{1, 2}},
+		{"more hours than left", hour(3), 10, []float64{4, 5}},
+		{"last hour", hour(4).Add(time.Minute), 6, []float64{5}},
+		{"after the forecast", hour(5), 6, nil},
+		{"zero hours", day, 0, []float64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := upcoming(forecasts, tt.now, tt.hours)
+			if len(got) != len(tt.want) || (tt.want == nil) != (got == nil) {
+				t.Fatalf("got %d forecasts, want %v", len(got), tt.want)
+			}
+			for i, f := range got {
+				if f.Celsius != tt.want[i] {
+					t.Errorf("forecast %d: got %v °C, want %v °C", i, f.Celsius, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestExtremes(t *testing.T) {
+	nan := math.NaN()
+	tests := []struct {
+		name                 string
+		temperatures         []float64
+		warmest, coldest     float64
+		warmestAt, coldestAt int
+	}{
+		{"mixed", []float64{12, 18, 9, 15}, 18, 9, 1, 2},
+		{"ties go to the earlier hour", []float64{5, 20, 5, 20}, 20, 5, 1, 0},
+		{"single forecast", []float64{7}, 7, 7, 0, 0},
+		{"below freezing", []float64{-3, -12, -1}, -1, -12, 2, 1},
+		{"skips missing hours", []float64{nan, 4, nan, 2}, 4, 2, 1, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			warmest, coldest := extremes(hours(day, tt.temperatures...))
+			if warmest.Celsius != tt.warmest || !warmest.Time.Equal(hour(tt.warmestAt)) {
+				t.Errorf("warmest: got %v °C at %v, want %v °C at %v", warmest.Celsius, warmest.Time, tt.warmest, hour(tt.warmestAt))
+			}
+			if coldest.Celsius != tt.coldest || !coldest.Time.Equal(hour(tt.coldestAt)) {
+				t.Errorf("coldest: got %v °C at %v, want %v °C at %v", coldest.Celsius, coldest.Time, tt.coldest, hour(tt.coldestAt))
+			}
+		})
+	}
+
+	for name, temperatures := range map[string][]float64{"empty": nil, "all missing": {nan, nan}} {
+		if warmest, coldest := extremes(hours(day, temperatures...)); !warmest.Time.IsZero() || !coldest.Time.IsZero() {
+			t.Errorf("%s: got %v and %v, want zero values", name, warmest, coldest)
+		}
+	}
+}
+
+// repeat returns n copies of celsius, for building whole days of forecasts.
+func repeat(celsius float64, n int) []float64 {
+	temperatures := make([]float64, n)
+	for i := range temperatures {
+		temperatures[i] = celsius
+	}
+	return temperatures
+}
+
+func TestDegreeDays(t *testing.T) {
+	nan := math.NaN()
+	tests := []struct {
+		name               string
+		temperatures       []float64
+		base               float64
+		wantHeat, wantCool float64
+	}{
+		{"cold day", repeat(10, 24), 18, 8, 0},
+		{"hot day", repeat(25, 24), 18, 0, 7},
+		{"at the base", repeat(18, 24), 18, 0, 0},
+		{"uses the daily mean", append(repeat(10, 12), repeat(20, 12)...), 18, 3, 0},
+		{"sums days separately", append(repeat(10, 24), repeat(25, 24)...), 18, 8, 7},
+		{"partial day counts fully", []float64{20, 22}, 18, 0, 3},
+		{"skips missing hours", []float64{12, nan, 12}, 18, 6, 0},
+		{"custom base", repeat(10, 24), 15.5, 5.5, 0},
+		{"no forecasts", nil, 18, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			heating, cooling := degreeDays(hours(day, tt.temperatures...), tt.base)
+			if math.Abs(heating-tt.wantHeat) > 1e-9 || math.Abs(cooling-tt.wantCool) > 1e-9 {
+				t.Errorf("got heating %v, cooling %v; want %v, %v", heating, cooling, tt.wantHeat, tt.wantCool)
+			}
+		})
+	}
+}
+
+func TestIsGoodWeather(t *testing.T) {
+	thresholds := picnicThresholds{MinCelsius: 15, MaxCelsius: 28, MaxPrecipitationMM: 1, MaxWindKmh: 30}
+	wind := func(kmh float64) *float64 { return &kmh }
+	tests := []struct {
+		name        string
+		day         DaySummary
+		wantScore   int
+		wantReasons []string
+	}{
+		{"good", DaySummary{Avg: 21, Precipitation: 0.5, MaxWind: wind(12)}, 100, nil},
+		{"at the limits", DaySummary{Avg: 28, Precipitation: 1, MaxWind: wind(30)}, 100, nil},
+		{"too cold", DaySummary{Avg: 9, MaxWind: wind(12)}, 66, []string{"too cold"}},
+		{"too hot and rainy", DaySummary{Avg: 31, Precipitation: 4, MaxWind: wind(12)}, 33, []string{"too hot", "rainy"}},
+		{"too windy", DaySummary{Avg: 20, MaxWind: wind(45)}, 66, []string{"too windy"}},
+		{"everything wrong", DaySummary{Avg: 2, Precipitation: 12, MaxWind: wind(60)}, 0, []string{"too cold", "rainy", "too windy"}},
+		{"no wind data", DaySummary{Avg: 20}, 100, nil},
+		{"no wind data and rainy", DaySummary{Avg: 20, Precipitation: 3}, 50, []string{"rainy"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			score, reasons := isGoodWeather(tt.day, thresholds)
+			if score != tt.wantScore || !reflect.DeepEqual(reasons, tt.wantReasons) {
+				t.Errorf("got score %d, reasons %q; want %d, %q", score, reasons, tt.wantScore, tt.wantReasons)
+			}
+		})
+	}
+}
+
+func TestSmoothForecasts(t *testing.T) {
+	nan := math.NaN()
+	tests := []struct {
+		name         string
+		alpha        float64
+		temperatures []float64
+		want         []string
+		wantErr      bool
+	}{
+		{"alpha 1 keeps readings", 1, []float64{10, 20, 30}, []string{"10.0°C", "20.0°C", "30.0°C"}, false},
+		{"alpha 0.5", 0.5, []float64{10, 20, 30}, []string{"10.0°C", "15.0°C", "22.5°C"}, false},
+		{"starts at the first reading", 0.5, []float64{nan, 10, 20}, []string{"—", "10.0°C", "15.0°C"}, false},
+		{"skips missing hours", 0.5, []float64{10, nan, 20}, []string{"10.0°C", "—", "15.0°C"}, false},
+		{"zero", 0, []float64{10}, nil, true},
+		{"above one", 1.5, []float64{10}, nil, true},
+		{"NaN", nan, []float64{10}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forecasts := hours(day, tt.temperatures...)
+			for i := range forecasts {
+				if forecasts[i].Missing {
+					forecasts[i].Temperature = weather.DefaultMissing
+				}
+			}
+			err := smoothForecasts(forecasts, tt.alpha, weather.DefaultFormat)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			for i, want := range tt.want {
+				if got := forecasts[i].SmoothedTemperature; got != want {
+					t.Errorf("hour %d: got %q, want %q", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestAggregateDaily(t *testing.T) {
+	nan := math.NaN()
+	lateEvening := time.Date(2024, 6, 1, 22, 0, 0, 0, berlin)
+	date := func(d int) time.Time { return time.Date(2024, 6, d, 0, 0, 0, 0, berlin) }
+	tests := []struct {
+		name         string
+		start        time.Time
+		temperatures []float64
+		want         []DaySummary
+	}{
+		{"one day", day, []float64{10, 14, 12}, []DaySummary{{Date: day, Hours: 3, Min: 10, Max: 14, Avg: 12}}},
+		{"splits at local midnight", lateEvening, []float64{18, 16, 12, 10}, []DaySummary{
+			{Date: date(1), Hours: 2, Min: 16, Max: 18, Avg: 17},
+			{Date: date(2), Hours: 2, Min: 10, Max: 12, Avg: 11},
+		}},
+		{"skips missing hours", day, []float64{nan, 8, nan, 4}, []DaySummary{{Date: day, Hours: 2, Min: 4, Max: 8, Avg: 6}}},
+		{"all missing", day, []float64{nan, nan}, nil},
+		{"empty", day, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := aggregateDaily(hours(tt.start, tt.temperatures...))
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d days, want %d", len(got), len(tt.want))
+			}
+			for i, want := range tt.want {
+				if !got[i].Date.Equal(want.Date) || got[i].Hours != want.Hours || got[i].Min != want.Min ||
+					got[i].Max != want.Max || got[i].Avg != want.Avg {
+					t.Errorf("day %d: got %+v, want %+v", i, got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestAggregateDailyPrecipitationAndWind(t *testing.T) {
+	amount := func(v float64) *float64 { return &v }
+	forecasts := hours(day, 10, 11, 12)
+	forecasts[0].PrecipitationMM, forecasts[0].WindKmh = amount(0.5), amount(12)
+	forecasts[1].PrecipitationMM, forecasts[1].WindKmh = amount(1.25), amount(30)
+	forecasts[2].WindKmh = amount(18)
+
+	days := aggregateDaily(forecasts)
+	if len(days) != 1 {
+		t.Fatalf("got %d days, want 1", len(days))
+	}
+	if days[0].Precipitation != 1.75 || days[0].MaxWind == nil || *days[0].MaxWind != 30 {
+		t.Errorf("got precipitation %v mm and max wind %v, want 1.75 mm and 30 km/h", days[0].Precipitation, days[0].MaxWind)
+	}
+	if days := aggregateDaily(hours(day, 10)); days[0].MaxWind != nil {
+		t.Errorf("got max wind %v for a day without wind data, want nil", *days[0].MaxWind)
+	}
+}
+
+func TestMergeForecasts(t *testing.T) {
+	// at returns the forecast for hour n with the given temperature, which
+	// tells the sources apart.
+	at := func(n int, celsius float64) weather.Forecast {
+		return weather.Forecast{Time: hour(n), Celsius: celsius}
+	}
+	tests := []struct {
+		name string
+		a, b []weather.Forecast
+		want []weather.Forecast
+	}{
+		{"overlapping prefers b", []weather.Forecast{at(0, 1), at(1, 1), at(2, 1)}, []weather.Forecast{at(1, 2), at(2, 2), at(3, 2)},
+			[]weather.Forecast{at(0, 1), at(1, 2), at(2, 2), at(3, 2)}},
+		{"disjoint", []weather.Forecast{at(0, 1), at(1, 1)}, []weather.Forecast{at(3, 2), at(4, 2)},
+			[]weather.Forecast{at(0, 1), at(1, 1), at(3, 2), at(4, 2)}},
+		{"b before a", []weather.Forecast{at(3, 1)}, []weather.Forecast{at(0, 2), at(1, 2)},
+			[]weather.Forecast{at(0, 2), at(1, 2), at(3, 1)}},
+		{"unsorted inputs", []weather.Forecast{at(2, 1), at(0, 1)}, []weather.Forecast{at(3, 2), at(2, 2), at(1, 2)},
+			[]weather.Forecast{at(0, 1), at(1, 2), at(2, 2), at(3, 2)}},
+		{"empty a", nil, []weather.Forecast{at(1, 2), at(0, 2)}, []weather.Forecast{at(0, 2), at(1, 2)}},
+		{"empty b", []weather.Forecast{at(0, 1)}, nil, []weather.Forecast{at(0, 1)}},
+		{"both empty", nil, nil, []weather.Forecast{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mergeForecasts(tt.a, tt.b)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// The same instant in another zone is the same hour.
+	got := mergeForecasts([]weather.Forecast{at(0, 1)}, []weather.Forecast{{Time: hour(0).In(berlin), Celsius: 2}})
+	if len(got) != 1 || got[0].Celsius != 2 {
+		t.Errorf("same instant in two zones: got %v, want b's forecast only", got)
+	}
+}
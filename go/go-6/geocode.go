@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mre/goforecast/internal/weather"
+	"golang.org/x/sync/singleflight"
+)
+
+// geocoder resolves city names to coordinates, remembering them in the cities
+// table so open-meteo is only asked once per city.
+type geocoder struct {
+	cities cityStore
+	queue  *upstreamQueue
+	// writer takes updates, touches and pruning of the cities table off the
+	// request path. Inserts stay on it; see lookup.
+	writer *dbWriter
+	// ttl is how long stored coordinates are trusted before the city is
+	// geocoded again. Zero keeps them forever.
+	ttl time.Duration
+	// maxCities caps the number of stored cities; the least recently
+	// requested ones are pruned past it. Zero means no cap.
+	maxCities int
+	// misses remembers names open-meteo knows nothing about, so repeated
+	// lookups of them fail without asking again. They never reach the
+	// cities table.
+	misses *missCache
+	// aliases maps lower-case alternative names, like "nyc", to the city
+	// names geocoded in their place.
+	aliases map[string]string
+	// allowed, if not empty, holds the lower-case names of the only cities
+	// that may be looked up.
+	allowed map[string]bool
+	// touching holds the names with a touch queued, so a popular city has at
+	// most one in the write queue at a time.
+	touching sync.Map
+	// lookups collapses concurrent lookups of the same city into one, so a
+	// burst of requests for a new city geocodes and inserts it only once.
+	lookups singleflight.Group
+}
+
+// cityStore is where a geocoder keeps the places it has resolved: the
+// cities table, through a *cityRepo.
+type cityStore interface {
+	find(name string) (storedCity, error)
+	insert(name string, place weather.Place) error
+	update(name string, place weather.Place) error
+	touch(name string) error
+	prune(max int) (int64, error)
+	delete(cache Cache, name string) (cities, forecasts int64, err error)
+}
+
+// ErrCityNotAllowed is matched by the error for a city outside
+// ALLOWED_CITIES.
+var ErrCityNotAllowed = errors.New("city not allowed")
+
+type notAllowedError struct {
+	city string
+}
+
+func (e *notAllowedError) Error() string {
+	return fmt.Sprintf("weather for %q is not available here", e.city)
+}
+
+func (e *notAllowedError) Is(target error) bool { return target == ErrCityNotAllowed }
+
+// maxGeoMisses caps how many unknown names a missCache remembers.
+const maxGeoMisses = 1000
+
+// missCache remembers names that geocoded to nothing for ttl. A nil
+// *missCache remembers nothing.
+type missCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	expires map[string]time.Time
+}
+
+func newMissCache(ttl time.Duration) *missCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &missCache{ttl: ttl, expires: make(map[string]time.Time)}
+}
+
+// has reports whether name missed within the last ttl.
+func (m *missCache) has(name string) bool {
+	if m == nil {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	expires, ok := m.expires[name]
+	if ok && time.Now().After(expires) {
+		delete(m.expires, name)
+		return false
+	}
+	return ok
+}
+
+// add remembers that name missed. Once full, expired entries are dropped to
+// make room; if none have expired, name isn't remembered.
+func (m *missCache) add(name string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	if len(m.expires) >= maxGeoMisses {
+		for n, expires := range m.expires {
+			if now.After(expires) {
+				delete(m.expires, n)
+			}
+		}
+		if len(m.expires) >= maxGeoMisses {
+			return
+		}
+	}
+	m.expires[name] = now.Add(m.ttl)
+}
+
+type storedCity struct {
+	weather.Place
+	GeocodedAt time.Time `db:"geocoded_at"`
+}
+
+// canonicalName returns the city name stands for if it is an alias, and name
+// otherwise.
+func (g *geocoder) canonicalName(name string) string {
+	if city, ok := g.aliases[strings.ToLower(strings.TrimSpace(name))]; ok {
+		return city
+	}
+	return name
+}
+
+// stored returns what the cities table holds for name, or the city it is an
+// alias of.
+func (g *geocoder) stored(name string) (storedCity, error) {
+	return g.cities.find(g.canonicalName(name))
+}
+
+// forget removes name, or the city it is an alias of, from the cities table
+// and its forecasts from cache; see cityRepo.delete.
+func (g *geocoder) forget(cache Cache, name string) (cities, forecasts int64, err error) {
+	return g.cities.delete(cache, g.canonicalName(name))
+}
+
+// checkAllowed returns an error matching ErrCityNotAllowed if city isn't on
+// the allowlist.
+func (g *geocoder) checkAllowed(city string) error {
+	if len(g.allowed) > 0 && !g.allowed[strings.ToLower(city)] {
+		return &notAllowedError{city}
+	}
+	return nil
+}
+
+// getLatLong resolves name, or the city it is an alias of, to coordinates and
+// the place they belong to, refusing cities that aren't allowed. The lookup
+// is shared with concurrent callers asking for the same name, so it isn't
+// cancelled along with ctx; the client's timeout still bounds it.
+func (g *geocoder) getLatLong(ctx context.Context, name string) (*weather.Place, error) {
+	name = g.canonicalName(name)
+	if err := g.checkAllowed(name); err != nil {
+		return nil, err
+	}
+	ctx = context.WithoutCancel(ctx)
+	v, err, _ := g.lookups.Do(name, func() (any, error) {
+		return g.lookup(ctx, name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*weather.Place), nil
+}
+
+func (g *geocoder) lookup(ctx context.Context, name string) (*weather.Place, error) {
+	city, err := g.cities.find(name)
+	found := err == nil
+	if found && (g.ttl == 0 || time.Since(city.GeocodedAt) < g.ttl) {
+		g.touch(name)
+		slog.Debug("city cache hit", "city", name)
+		return &city.Place, nil
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		slog.Warn("error reading cities", "city", name, "error", err)
+	}
+
+	if !found && g.misses.has(name) {
+		slog.Debug("city known to be unknown", "city", name)
+		return nil, weather.ErrNoResults
+	}
+
+	var place *weather.Place
+	err = g.queue.Do(ctx, func() (err error) {
+		slog.Debug("geocoding city", "city", name, "stored", found)
+		place, err = weather.FetchLatLong(ctx, name)
+		return err
+	})
+	if err != nil {
+		if found {
+			slog.Warn("re-geocoding failed, keeping stored coordinates", "city", name, "error", err)
+			return &city.Place, nil
+		}
+		if errors.Is(err, weather.ErrNoResults) {
+			g.misses.add(name)
+		}
+		return nil, err
+	}
+
+	if found {
+		g.writer.Submit("update city "+name, func() error {
+			return g.cities.update(name, *place)
+		})
+		return place, nil
+	}
+	// The insert happens here rather than through writer: lookups only
+	// collapse while this one runs, so until the row exists the next lookup
+	// would geocode and insert the city again.
+	if err := g.cities.insert(name, *place); err != nil {
+		slog.Warn("error storing city", "city", name, "error", err)
+		return place, nil
+	}
+	if g.maxCities > 0 {
+		g.writer.Submit("prune cities", func() error {
+			pruned, err := g.cities.prune(g.maxCities)
+			if pruned > 0 {
+				slog.Info("pruned least recently requested cities", "pruned", pruned, "max", g.maxCities)
+			}
+			return err
+		})
+	}
+	return place, nil
+}
+
+// touch queues a write recording that name was just requested, unless one
+// is queued already. The touch only feeds MAX_CITIES pruning, so a request
+// that finds one pending loses nothing by skipping its own.
+func (g *geocoder) touch(name string) {
+	if _, queued := g.touching.LoadOrStore(name, true); queued {
+		return
+	}
+	queued := g.writer.Submit("touch city "+name, func() error {
+		g.touching.Delete(name)
+		return g.cities.touch(name)
+	})
+	if !queued {
+		g.touching.Delete(name)
+	}
+}
+
+// usBounds are rough bounding boxes around the contiguous US, Alaska and
+// Hawaii. They also take in bits of Canada and Mexico, which is fine for
+// picking a default unit.
+var usBounds = []struct{ minLat, maxLat, minLon, maxLon float64 }{
+	{24.5, 49.4, -124.8, -66.9},
+	{51.2, 71.5, -179.2, -129.9},
+	{18.9, 22.3, -160.3, -154.8},
+}
+
+// defaultUnitForCoords returns Fahrenheit for coordinates in the US and
+// Celsius everywhere else.
+func defaultUnitForCoords(lat, lon float64) weather.Unit {
+	for _, b := range usBounds {
+		if lat >= b.minLat && lat <= b.maxLat && lon >= b.minLon && lon <= b.maxLon {
+			return weather.Fahrenheit
+		}
+	}
+	return weather.Celsius
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mre/goforecast/internal/weather"
+)
+
+// memoryCities is a cityStore kept in a map, for tests.
+type memoryCities struct {
+	mu      sync.Mutex
+	cities  map[string]storedCity
+	inserts int
+	touches int
+}
+
+func newMemoryCities() *memoryCities {
+	return &memoryCities{cities: make(map[string]storedCity)}
+}
+
+func (m *memoryCities) find(name string) (storedCity, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	city, ok := m.cities[name]
+	if !ok {
+		return storedCity{}, sql.ErrNoRows
+	}
+	return city, nil
+}
+
+func (m *memoryCities) insert(name string, place weather.Place) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inserts++
+	m.cities[name] = storedCity{Place: place, GeocodedAt: time.Now()}
+	return nil
+}
+
+func (m *memoryCities) update(name string, place weather.Place) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cities[name] = storedCity{Place: place, GeocodedAt: time.Now()}
+	return nil
+}
+
+func (m *memoryCities) touch(string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.touches++
+	return nil
+}
+
+func (m *memoryCities) prune(int) (int64, error) { return 0, nil }
+
+func newTestGeocoder(cities cityStore) *geocoder {
+	return &geocoder{
+		cities: cities,
+		queue:  newUpstreamQueue(4, 64, &retrier{budget: newRetryBudget(0)}),
+		writer: newDBWriter(1, 64, &backgroundTasks{}),
+		misses: newMissCache(time.Minute),
+	}
+}
+
+func TestMissCache(t *testing.T) {
+	tests := []struct {
+		name  string
+		cache *missCache
+		add   []string
+		want  map[string]bool
+	}{
+		{"disabled", newMissCache(0), []string{"atlantis"}, map[string]bool{"atlantis": false}},
+		{"remembers misses", newMissCache(time.Minute), []string{"atlantis"}, map[string]bool{"atlantis": true, "berlin": false}},
+		{"forgets expired misses", newMissCache(time.Nanosecond), []string{"atlantis"}, map[string]bool{"atlantis": false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range tt.add {
+				tt.cache.add(name)
+			}
+			time.Sleep(time.Millisecond)
+			for name, want := range tt.want {
+				if got := tt.cache.has(name); got != want {
+					t.Errorf("has(%q) = %t, want %t", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMissCacheFull(t *testing.T) {
+	m := newMissCache(time.Minute)
+	for i := 0; i < maxGeoMisses; i++ {
+		m.expires[fmt.Sprintf("city %d", i)] = time.Now().Add(time.Minute)
+	}
+	m.add("atlantis")
+	if m.has("atlantis") {
+		t.Error("full cache remembered another miss")
+	}
+
+	for name := range m.expires {
+		m.expires[name] = time.Now().Add(-time.Second)
+		break
+	}
+	m.add("atlantis")
+	if !m.has("atlantis") {
+		t.Error("miss not remembered after an entry expired")
+	}
+}
+
+func TestGeocoderMisses(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		wantErr    error
+		wantMissed bool
+	}{
+		{"no results", http.StatusOK, `{"generationtime_ms":0.5}`, weather.ErrNoResults, true},
+		{"empty results", http.StatusOK, `{"results":[]}`, weather.ErrNoResults, true},
+		{"server error", http.StatusInternalServerError, `{"error":true,"reason":"database down"}`, nil, false},
+		{"bad gateway without a body", http.StatusBadGateway, ``, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int64
+			serveUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			})
+			g := newTestGeocoder(newMemoryCities())
+
+			for i := 0; i < 2; i++ {
+				_, err := g.getLatLong(context.Background(), "Atlantis")
+				if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got error %v, want %v", err, tt.wantErr)
+				}
+				if tt.wantErr == nil && !errors.As(err, new(*weather.StatusError)) {
+					t.Fatalf("got error %v, want a *weather.StatusError", err)
+				}
+			}
+			if got := g.misses.has("Atlantis"); got != tt.wantMissed {
+				t.Errorf("miss recorded = %t, want %t", got, tt.wantMissed)
+			}
+			wantRequests := int64(2)
+			if tt.wantMissed {
+				wantRequests = 1
+			}
+			if got := requests.Load(); got != wantRequests {
+				t.Errorf("geocoding API asked %d times, want %d", got, wantRequests)
+			}
+		})
+	}
+}
+
+func TestGeocoderAllowlist(t *testing.T) {
+	var requests atomic.Int64
+	serveUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Write([]byte(`{"results":[{"name":"Berlin","latitude":52.52,"longitude":13.41,"country":"Germany"}]}`))
+	})
+	g := newTestGeocoder(newMemoryCities())
+	g.allowed = map[string]bool{"berlin": true}
+	g.aliases = map[string]string{"hauptstadt": "Berlin", "ville lumière": "Paris"}
+
+	tests := []struct {
+		city    string
+		allowed bool
+	}{
+		{"Berlin", true},
+		{"BERLIN", true},
+		{"Hauptstadt", true},
+		{"Paris", false},
+		{"Ville Lumière", false},
+	}
+	for _, tt := range tests {
+		before := requests.Load()
+		_, err := g.getLatLong(context.Background(), tt.city)
+		if tt.allowed && err != nil {
+			t.Errorf("%s: unexpected error %v", tt.city, err)
+		}
+		if !tt.allowed {
+			if !errors.Is(err, ErrCityNotAllowed) || errorStatus(err) != http.StatusForbidden {
+				t.Errorf("%s: got error %v, want a 403 ErrCityNotAllowed", tt.city, err)
+			}
+			if requests.Load() != before {
+				t.Errorf("%s: geocoded a city that isn't allowed", tt.city)
+			}
+		}
+	}
+}
+
+func (m *memoryCities) delete(_ Cache, name string) (int64, int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.cities[name]; !ok {
+		return 0, 0, nil
+	}
+	delete(m.cities, name)
+	return 1, 0, nil
+}
+
+func TestGeocoderAliases(t *testing.T) {
+	var names []string
+	serveUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		names = append(names, r.URL.Query().Get("name"))
+		w.Write([]byte(`{"results":[{"name":"New York","latitude":40.71,"longitude":-74.01,"country":"United States"}]}`))
+	})
+	cities := newMemoryCities()
+	g := newTestGeocoder(cities)
+	// Aliases resolve once: "New York" isn't looked up as an alias again.
+	g.aliases = map[string]string{"nyc": "New York", "new york": "New York City"}
+
+	if _, err := g.getLatLong(context.Background(), " NYC "); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	g.writer.pending.Wait()
+	if len(names) != 1 || names[0] != "New York" {
+		t.Errorf("geocoded %q, want only the alias's city", names)
+	}
+
+	if _, err := g.stored("nyc"); err != nil {
+		t.Errorf("stored(nyc): %v", err)
+	}
+	if n, _, err := g.forget(newMemoryCache(), "NYC"); err != nil || n != 1 {
+		t.Errorf("forget(NYC) removed %d cities, error %v; want 1", n, err)
+	}
+	if _, err := g.stored("New York"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("New York still stored after forgetting its alias")
+	}
+}
+
+func TestGeocoderInsertsOnce(t *testing.T) {
+	var requests atomic.Int64
+	serveUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte(`{"results":[{"name":"Berlin","latitude":52.52,"longitude":13.41,"country":"Germany"}]}`))
+	})
+	cities := newMemoryCities()
+	g := newTestGeocoder(cities)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := g.getLatLong(context.Background(), "Berlin"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	// A lookup right after the burst must find the stored row.
+	if _, err := g.getLatLong(context.Background(), "Berlin"); err != nil {
+		t.Fatal(err)
+	}
+	g.writer.pending.Wait()
+
+	if got := requests.Load(); got != 1 {
+		t.Errorf("geocoded %d times, want 1", got)
+	}
+	if cities.inserts != 1 {
+		t.Errorf("inserted %d rows, want 1", cities.inserts)
+	}
+}
+
+func TestGeocoderCoalescesTouches(t *testing.T) {
+	cities := newMemoryCities()
+	cities.cities["Berlin"] = storedCity{Place: weather.Place{Name: "Berlin"}, GeocodedAt: time.Now()}
+	cities.cities["Paris"] = storedCity{Place: weather.Place{Name: "Paris"}, GeocodedAt: time.Now()}
+	g := newTestGeocoder(cities)
+	// Nothing runs the queue until the test is done looking up, so the
+	// touches pile up.
+	g.writer = &dbWriter{jobs: make(chan dbWrite, 4), pending: &backgroundTasks{}}
+
+	for i := 0; i < 100; i++ {
+		for _, city := range []string{"Berlin", "Paris"} {
+			if _, err := g.getLatLong(context.Background(), city); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if got := len(g.writer.jobs); got != 2 {
+		t.Errorf("queued %d writes, want one touch per city", got)
+	}
+
+	go g.writer.run()
+	g.writer.pending.Wait()
+	if _, err := g.getLatLong(context.Background(), "Berlin"); err != nil {
+		t.Fatal(err)
+	}
+	g.writer.pending.Wait()
+	if cities.touches != 3 {
+		t.Errorf("touched %d times, want 3", cities.touches)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import "time"
+
+// featureCollection is the subset of GeoJSON (RFC 7946) needed to put the
+// cached cities on a map.
+type featureCollection struct {
+	Type     string    `json:"type"`
+	Features []feature `json:"features"`
+}
+
+type feature struct {
+	Type       string            `json:"type"`
+	Geometry   point             `json:"geometry"`
+	Properties featureProperties `json:"properties"`
+}
+
+type point struct {
+	Type string `json:"type"`
+	// Coordinates are longitude then latitude, as GeoJSON orders them.
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+type featureProperties struct {
+	Name            string    `json:"name"`
+	LastRequestedAt time.Time `json:"last_requested_at"`
+}
+
+// citiesGeoJSON turns cities into a FeatureCollection of points.
+func citiesGeoJSON(cities []cachedCity) featureCollection {
+	collection := featureCollection{Type: "FeatureCollection", Features: make([]feature, 0, len(cities))}
+	for _, city := range cities {
+		collection.Features = append(collection.Features, feature{
+			Type:     "Feature",
+			Geometry: point{Type: "Point", Coordinates: [2]float64{city.Longitude, city.Latitude}},
+			Properties: featureProperties{
+				Name:            city.Name,
+				LastRequestedAt: city.LastRequestedAt,
+			},
+		})
+	}
+	return collection
+}
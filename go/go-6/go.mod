@@ -1,11 +1,16 @@
 module github.com/mre/goforecast
 
-go 1.19
+go 1.21
 
 require (
 	github.com/gin-gonic/gin v1.9.1
+	github.com/golang-jwt/jwt/v5 v5.2.1
 	github.com/jmoiron/sqlx v1.3.5
 	github.com/lib/pq v1.2.0
+	github.com/mre/goforecast/internal v0.0.0
+	golang.org/x/sync v0.6.0
+	golang.org/x/text v0.13.0
+	golang.org/x/time v0.5.0
 )
 
 require (
@@ -31,7 +36,8 @@ require (
 	golang.org/x/crypto v0.13.0 // indirect
 	golang.org/x/net v0.15.0 // indirect
 	golang.org/x/sys v0.12.0 // indirect
-	golang.org/x/text v0.13.0 // indirect
 	google.golang.org/protobuf v1.31.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
+
+replace github.com/mre/goforecast/internal => ../internal
@@ -0,0 +1,711 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mre/goforecast/internal/weather"
+	"golang.org/x/text/language"
+)
+
+// handlers serves the HTTP API. Its fields are shared by all requests.
+type handlers struct {
+	cfg       Config
+	html      htmlRenderer
+	formats   formatNegotiator
+	db        *timeoutDB
+	replica   *timeoutDB
+	repo      *cityRepo
+	queue     *upstreamQueue
+	geo       *geocoder
+	forecasts *weatherCache
+	// alerter and locator are nil unless ALERTS_URL and
+	// IP_GEOLOCATION_URL are set.
+	alerter Alerter
+	locator *ipLocator
+	// warmed is closed once the cache warmer has finished its first pass;
+	// until then the instance reports itself as not ready.
+	warmed chan struct{}
+}
+
+// register adds the routes to r. limiter rate limits the public endpoints
+// and cacheResponses caches their rendered responses.
+func (h *handlers) register(r *gin.Engine, limiter, cacheResponses gin.HandlerFunc) {
+	r.GET("/", h.index)
+
+	// /healthz only tells whether the process is up; /ready additionally
+	// checks that the database is reachable and the caches are warm.
+	r.Match([]string{http.MethodGet, http.MethodHead}, "/healthz", h.healthz)
+	r.GET("/ready", h.ready)
+
+	r.Match([]string{http.MethodGet, http.MethodHead}, "/weather", limiter, cacheResponses, h.weather)
+	r.GET("/weather/postal", limiter, cacheResponses, h.postalWeather)
+	r.GET("/weather/trend", limiter, h.trend)
+	r.GET("/weather/extremes", limiter, h.extremes)
+	r.GET("/weather/degreedays", limiter, h.degreeDays)
+	r.GET("/weather/picnic", limiter, h.picnic)
+	r.GET("/cities/nearest", limiter, h.nearestCity)
+	r.GET("/history/diff", limiter, h.historyDiff)
+
+	auth := adminAuth(h.cfg)
+	r.GET("/stats", auth, h.stats)
+	r.GET("/cities", auth, h.cities)
+	r.GET("/cities.geojson", auth, h.citiesGeoJSON)
+	r.GET("/debug/vars", auth, gin.WrapH(expvar.Handler()))
+	r.GET("/debug/config", auth, h.debugConfig)
+	r.GET("/debug/raw", auth, h.debugRaw)
+	r.GET("/cache/stats", auth, h.cacheStats)
+	r.POST("/cache/warm", auth, h.warmCity)
+	r.DELETE("/cache", auth, h.deleteCity)
+}
+
+func (h *handlers) index(c *gin.Context) {
+	h.html.render(c, http.StatusOK, "index.html", nil)
+}
+
+func (h *handlers) healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func (h *handlers) ready(c *gin.Context) {
+	select {
+	case <-h.warmed:
+	default:
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "warming cache"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Second)
+	defer cancel()
+	if err := h.db.PingContext(ctx); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "database unreachable", "error": err.Error()})
+		return
+	}
+	if h.replica != nil {
+		if err := h.replica.PingContext(ctx); err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "read replica unreachable", "error": err.Error()})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ready"})
+}
+
+// geocodeFailed answers a failed location lookup. Unknown places get the
+// configured hint instead of the bare "no results found"; ambiguous names
+// get 300 Multiple Choices with the candidates to choose from.
+func (h *handlers) geocodeFailed(c *gin.Context, err error) {
+	if errors.Is(err, weather.ErrNoResults) {
+		c.JSON(http.StatusNotFound, gin.H{"error": h.cfg.NoResultsMessage})
+		return
+	}
+	var ambiguous *weather.AmbiguousCityError
+	if errors.As(err, &ambiguous) {
+		c.JSON(http.StatusMultipleChoices, gin.H{"error": err.Error(), "candidates": ambiguous.Candidates})
+		return
+	}
+	c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+}
+
+// resolveCity geocodes the city in ?city=, which the geocoder checks against
+// ALLOWED_CITIES. If that fails it answers the request and returns false.
+func (h *handlers) resolveCity(c *gin.Context) (city string, place *weather.Place, ok bool) {
+	city = c.Query("city")
+	if city == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing city parameter"})
+		return "", nil, false
+	}
+	place, err := h.geo.getLatLong(c.Request.Context(), city)
+	if err != nil {
+		h.geocodeFailed(c, err)
+		return "", nil, false
+	}
+	return city, place, true
+}
+
+// resolveForecast is resolveCity followed by looking up and extracting the
+// city's forecast with the default parameters, as the endpoints that
+// summarize a forecast need. It sets the lookup headers and reports how the
+// forecast was obtained.
+func (h *handlers) resolveForecast(c *gin.Context) (place *weather.Place, display weather.WeatherDisplay, info lookupInfo, ok bool) {
+	city, place, ok := h.resolveCity(c)
+	if !ok {
+		return nil, weather.WeatherDisplay{}, lookupInfo{}, false
+	}
+	forecast, info, err := h.forecasts.Lookup(c.Request.Context(), place.LatLong, weather.ForecastParams{})
+	setLookupHeaders(c, info)
+	if err == nil {
+		display, err = weather.ExtractWeatherData(city, forecast, h.cfg.extractOptions())
+	}
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return nil, weather.WeatherDisplay{}, info, false
+	}
+	return place, display, info, true
+}
+
+// serveWeather renders the forecast for a resolved location, applying the
+// display options shared by all weather endpoints.
+func (h *handlers) serveWeather(c *gin.Context, query string, place weather.Place) {
+	format, err := h.formats.pick(c, formatHTML, formatJSON, formatCSV, formatICS, formatSeries)
+	if err != nil {
+		c.JSON(http.StatusNotAcceptable, gin.H{"error": err.Error()})
+		return
+	}
+	opts := h.cfg.extractOptions()
+	if locale := c.Query("locale"); locale != "" {
+		tag, err := language.Parse(locale)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid locale %q", locale)})
+			return
+		}
+		opts.Format.Locale = tag
+	}
+	if units := c.Query("units"); units != "" {
+		unit, err := weather.ParseUnit(units)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		opts.Format.Unit = unit
+	} else if h.cfg.AutoUnit {
+		opts.Format.Unit = defaultUnitForCoords(place.Latitude, place.Longitude)
+	}
+
+	// alertBelow and alertAbove are given in the requested unit.
+	alertBelow, err := parseThreshold(c.Query("alertBelow"), opts.Format.Unit)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid alertBelow: %v", err)})
+		return
+	}
+	alertAbove, err := parseThreshold(c.Query("alertAbove"), opts.Format.Unit)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid alertAbove: %v", err)})
+		return
+	}
+
+	// loc is the zone JSON responses report times in.
+	loc := h.cfg.OutputTimezone
+	if tz := c.Query("tz"); tz != "" {
+		loc, err = time.LoadLocation(tz)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid tz %q", tz)})
+			return
+		}
+	}
+
+	params := weather.ForecastParams{Model: c.Query("model"), Daily: c.Query("view") == "full"}
+	if params.Model != "" && !weather.ValidModel(params.Model) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown model %q, want one of %s", params.Model, strings.Join(weather.Models, ", "))})
+		return
+	}
+	if value := c.Query("days"); value != "" {
+		days, err := strconv.Atoi(value)
+		if err != nil || days < 1 || days > weather.MaxForecastDays {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid days %q, want 1 to %d", value, weather.MaxForecastDays)})
+			return
+		}
+		params.Days = days
+	}
+	if value := c.Query("elevation"); value != "" {
+		elevation, err := strconv.ParseFloat(value, 64)
+		if err != nil || elevation < minElevation || elevation > maxElevation {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid elevation %q, want metres between %d and %d", value, minElevation, maxElevation)})
+			return
+		}
+		elevation = math.Round(elevation)
+		params.Elevation = &elevation
+	}
+
+	forecast, info, err := h.forecasts.Lookup(c.Request.Context(), place.LatLong, params)
+	setLookupHeaders(c, info)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	if format == formatSeries {
+		series, err := forecastSeries(forecast, h.cfg.MaxForecastEntries)
+		if err != nil {
+			c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, series)
+		return
+	}
+
+	weatherDisplay, err := weather.ExtractWeatherData(query, forecast, opts)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	weatherDisplay.Place = place.FullName()
+	if weatherDisplay.Truncated {
+		slog.Warn("truncated oversized forecast", "place", query, "max", h.cfg.MaxForecastEntries)
+	}
+	if weatherDisplay.Implausible > 0 {
+		slog.Warn("dropped implausible temperatures", "place", query, "hours", weatherDisplay.Implausible,
+			"min", h.cfg.MinCelsius, "max", h.cfg.MaxCelsius)
+	}
+
+	if smooth := c.Query("smooth"); smooth != "" {
+		alpha, err := strconv.ParseFloat(smooth, 64)
+		if err == nil {
+			err = smoothForecasts(weatherDisplay.Forecasts, alpha, opts.Format)
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid smooth parameter: %v", err)})
+			return
+		}
+		weatherDisplay.Smoothed = true
+	}
+
+	if from, to := c.Query("from"), c.Query("to"); from != "" || to != "" {
+		window, err := parseTimeWindow(from, to)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid time range: %v", err)})
+			return
+		}
+		weatherDisplay.Forecasts = filterForecasts(weatherDisplay.Forecasts, window)
+	}
+
+	if format == formatICS {
+		days := aggregateDaily(weatherDisplay.Forecasts)
+		name := place.FullName()
+		if name == "" {
+			name = query
+		}
+		c.Data(http.StatusOK, "text/calendar; charset=utf-8", dailyCalendar(name, days, opts.Format, time.Now()))
+		return
+	}
+	if c.Query("view") == "daily" {
+		h.html.render(c, http.StatusOK, "daily.html", DailyDisplay{
+			City:   query,
+			Place:  place.FullName(),
+			Days:   aggregateDaily(weatherDisplay.Forecasts),
+			Format: opts.Format,
+		})
+		return
+	}
+	if c.Query("view") == "full" {
+		days, err := weather.GroupByDay(forecast, weatherDisplay.Forecasts, opts)
+		if err != nil {
+			c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		for _, day := range days {
+			inTimezone(day.Hourly, loc)
+		}
+		c.JSON(http.StatusOK, weather.FullDisplay{City: query, Place: place.FullName(), Days: days})
+		return
+	}
+	// Warnings are extra information; the forecast is served without
+	// them if the alerts service fails.
+	var warnings []weather.Alert
+	if h.alerter != nil {
+		warnings, err = h.alerter.Alerts(c.Request.Context(), place.LatLong)
+		if err != nil {
+			slog.Warn("could not fetch weather alerts", "place", query, "error", err)
+		}
+	}
+
+	switch format {
+	case formatJSON:
+		inTimezone(weatherDisplay.Forecasts, loc)
+		weatherDisplay.Alerts = append(temperatureAlerts(weatherDisplay.Forecasts, alertBelow, alertAbove, opts.Format), warnings...)
+		if c.Query("stream") == "true" {
+			streamJSON(c, weatherDisplay)
+			return
+		}
+		c.JSON(http.StatusOK, weatherDisplay)
+	case formatCSV:
+		inTimezone(weatherDisplay.Forecasts, loc)
+		forecastCSV(c, weatherDisplay.Forecasts)
+	default:
+		weatherDisplay.Alerts = append(temperatureAlerts(weatherDisplay.Forecasts, alertBelow, alertAbove, opts.Format), warnings...)
+		h.html.render(c, http.StatusOK, "weather.html", newWeatherPage(weatherDisplay, h.cfg.HTMLVariables, opts.Format.Unit, c.Request.URL))
+	}
+}
+
+// weather answers /weather?city=, or /weather without a city with the
+// forecast for the client's approximate location.
+func (h *handlers) weather(c *gin.Context) {
+	if c.Query("city") == "" {
+		h.localWeather(c)
+		return
+	}
+	city, place, ok := h.resolveCity(c)
+	if !ok {
+		return
+	}
+	h.serveWeather(c, city, *place)
+}
+
+// localWeather answers /weather without a city: with the forecast for the
+// client's approximate location if IP geolocation is enabled, otherwise by
+// sending browsers back to the search form.
+func (h *handlers) localWeather(c *gin.Context) {
+	if h.locator == nil {
+		if format, _ := h.formats.pick(c, formatHTML, formatJSON); format != formatHTML {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "missing city parameter"})
+			return
+		}
+		c.Redirect(http.StatusFound, "/")
+		return
+	}
+	place, err := h.locator.locate(c.Request.Context(), c.ClientIP())
+	if errors.Is(err, ErrNoClientLocation) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.geo.checkAllowed(place.Name); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	// The response depends on the client address, which isn't part of
+	// the response cache key.
+	c.Header("Cache-Control", "private")
+	h.serveWeather(c, place.Name, *place)
+}
+
+func (h *handlers) postalWeather(c *gin.Context) {
+	code, country := strings.TrimSpace(c.Query("code")), c.Query("country")
+	if !postalCodePattern.MatchString(code) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid postal code %q", code)})
+		return
+	}
+	if country != "" && !countryCodePattern.MatchString(country) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid country code %q, want two letters such as DE", country)})
+		return
+	}
+
+	var place *weather.Place
+	err := h.queue.Do(c.Request.Context(), func() (err error) {
+		place, err = weather.FetchPostalCode(c.Request.Context(), code, country)
+		return err
+	})
+	if err == nil {
+		err = h.geo.checkAllowed(place.Name)
+	}
+	if err != nil {
+		h.geocodeFailed(c, err)
+		return
+	}
+	h.serveWeather(c, code, *place)
+}
+
+func (h *handlers) trend(c *gin.Context) {
+	hours := 6
+	if value := c.Query("hours"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 2 || n > 48 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid hours %q, want 2 to 48", value)})
+			return
+		}
+		hours = n
+	}
+
+	_, display, _, ok := h.resolveForecast(c)
+	if !ok {
+		return
+	}
+	next := upcoming(display.Forecasts, time.Now(), hours)
+	direction, slope, err := trend(next)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"city":             display.City,
+		"trend":            direction,
+		"celsius_per_hour": math.Round(slope*100) / 100,
+		"hours":            len(next),
+	})
+}
+
+func (h *handlers) extremes(c *gin.Context) {
+	_, display, _, ok := h.resolveForecast(c)
+	if !ok {
+		return
+	}
+	next := upcoming(display.Forecasts, time.Now(), len(display.Forecasts))
+	if len(next) == 0 {
+		c.JSON(errorStatus(weather.ErrNoForecastData), gin.H{"error": weather.ErrNoForecastData.Error()})
+		return
+	}
+	warmest, coldest := extremes(next)
+	c.JSON(http.StatusOK, gin.H{
+		"city":    display.City,
+		"warmest": warmest,
+		"coldest": coldest,
+	})
+}
+
+func (h *handlers) degreeDays(c *gin.Context) {
+	base := defaultDegreeDayBase
+	if value := c.Query("base"); value != "" {
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid base %q, want a temperature in °C", value)})
+			return
+		}
+		base = v
+	}
+
+	_, display, _, ok := h.resolveForecast(c)
+	if !ok {
+		return
+	}
+	heating, cooling := degreeDays(display.Forecasts, base)
+	c.JSON(http.StatusOK, gin.H{
+		"city":         display.City,
+		"base_celsius": base,
+		"heating":      math.Round(heating*100) / 100,
+		"cooling":      math.Round(cooling*100) / 100,
+		"days":         len(aggregateDaily(display.Forecasts)),
+	})
+}
+
+func (h *handlers) picnic(c *gin.Context) {
+	_, display, _, ok := h.resolveForecast(c)
+	if !ok {
+		return
+	}
+	days := []gin.H{}
+	for _, day := range aggregateDaily(display.Forecasts) {
+		score, reasons := isGoodWeather(day, h.cfg.Picnic)
+		if reasons == nil {
+			reasons = []string{}
+		}
+		days = append(days, gin.H{
+			"date":    day.Date.Format("2006-01-02"),
+			"good":    len(reasons) == 0,
+			"score":   score,
+			"reasons": reasons,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"city": display.City, "days": days})
+}
+
+func (h *handlers) nearestCity(c *gin.Context) {
+	lat, err := strconv.ParseFloat(c.Query("lat"), 64)
+	if err != nil || lat < -90 || lat > 90 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid lat %q, want -90 to 90", c.Query("lat"))})
+		return
+	}
+	lon, err := strconv.ParseFloat(c.Query("lon"), 64)
+	if err != nil || lon < -180 || lon > 180 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid lon %q, want -180 to 180", c.Query("lon"))})
+		return
+	}
+
+	city, err := h.repo.nearest(weather.LatLong{Latitude: lat, Longitude: lon})
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no cities cached yet"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	city.DistanceKm = math.Round(city.DistanceKm*10) / 10
+	c.JSON(http.StatusOK, city)
+}
+
+func (h *handlers) historyDiff(c *gin.Context) {
+	from, err := time.Parse(time.RFC3339, c.Query("from"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid from parameter: %v", err)})
+		return
+	}
+	to, err := parseTimeParam(c.Query("to"), time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid to parameter: %v", err)})
+		return
+	}
+
+	city, place, ok := h.resolveCity(c)
+	if !ok {
+		return
+	}
+	key := weatherCacheKey(place.LatLong, weather.ForecastParams{})
+	var series [2][]weather.Forecast
+	var fetched [2]time.Time
+	for i, at := range []time.Time{from, to} {
+		snap, err := h.forecasts.history.At(key, at)
+		if err != nil {
+			c.JSON(errorStatus(err), gin.H{"error": fmt.Sprintf("%v before %s", err, at.Format(time.RFC3339))})
+			return
+		}
+		var display weather.WeatherDisplay
+		forecast, err := weather.DecodeWeather(snap.Value)
+		if err == nil {
+			display, err = weather.ExtractWeatherData(city, forecast, h.cfg.extractOptions())
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		series[i], fetched[i] = display.Forecasts, snap.FetchedAt
+	}
+
+	diff := diffForecasts(series[0], series[1])
+	diff.From, diff.To = fetched[0], fetched[1]
+	c.JSON(http.StatusOK, diff)
+}
+
+func (h *handlers) stats(c *gin.Context) {
+	cities, err := h.repo.last()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	format, err := h.formats.pick(c, formatHTML, formatJSON, formatCSV)
+	if err != nil {
+		c.JSON(http.StatusNotAcceptable, gin.H{"error": err.Error()})
+		return
+	}
+	switch format {
+	case formatJSON:
+		if cities == nil {
+			cities = []string{}
+		}
+		c.JSON(http.StatusOK, cities)
+	case formatCSV:
+		rows := make([][]string, len(cities))
+		for i, city := range cities {
+			rows[i] = []string{city}
+		}
+		writeCSV(c, []string{"name"}, rows)
+	default:
+		h.html.render(c, http.StatusOK, "stats.html", cities)
+	}
+}
+
+func (h *handlers) cities(c *gin.Context) {
+	limit := 50
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > 500 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid limit %q, want 1 to 500", value)})
+			return
+		}
+		limit = n
+	}
+	cities, err := h.repo.search(c.Query("q"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, cities)
+}
+
+func (h *handlers) citiesGeoJSON(c *gin.Context) {
+	cities, err := h.repo.all()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	// c.JSON keeps a Content-Type that is already set.
+	c.Header("Content-Type", "application/geo+json")
+	c.JSON(http.StatusOK, citiesGeoJSON(cities))
+}
+
+func (h *handlers) debugConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, h.cfg.redacted())
+}
+
+// debugRaw returns the cached open-meteo response for a city exactly as it
+// was received, for reproducing extraction problems.
+func (h *handlers) debugRaw(c *gin.Context) {
+	city, place, ok := h.resolveCity(c)
+	if !ok {
+		return
+	}
+	key := weatherCacheKey(place.LatLong, weather.ForecastParams{})
+	entry, err := h.forecasts.cache.Get(key)
+	if errors.Is(err, ErrCacheMiss) {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("no cached forecast for %q", city)})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("X-Cache-Key", key)
+	c.Header("X-Fetched-At", entry.FetchedAt.Format(time.RFC3339))
+	c.Header("X-Expires-At", entry.ExpiresAt.Format(time.RFC3339))
+	c.Data(http.StatusOK, "application/json", entry.Value)
+}
+
+func (h *handlers) cacheStats(c *gin.Context) {
+	stats, err := h.forecasts.cache.Stats(c.Query("reset") == "true")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+// warmCity geocodes and fetches a city right away, e.g. ahead of an expected
+// traffic spike, and reports whether it was cached already.
+func (h *handlers) warmCity(c *gin.Context) {
+	geocodeCached := false
+	if city := c.Query("city"); city != "" {
+		_, err := h.geo.stored(city)
+		geocodeCached = err == nil
+	}
+	place, display, info, ok := h.resolveForecast(c)
+	if !ok {
+		return
+	}
+	if info.Cache == cacheStale {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "could not fetch a fresh forecast, only a stale one is cached"})
+		return
+	}
+
+	forecastCached := info.Cache == cacheHit
+	status := "warmed"
+	if geocodeCached && forecastCached {
+		status = "already_warm"
+	}
+	warmest, coldest := extremes(display.Forecasts)
+	c.JSON(http.StatusOK, gin.H{
+		"city":            display.City,
+		"place":           place.FullName(),
+		"status":          status,
+		"geocode_cached":  geocodeCached,
+		"forecast_cached": forecastCached,
+		"hours":           len(display.Forecasts),
+		"warmest":         warmest,
+		"coldest":         coldest,
+	})
+}
+
+func (h *handlers) deleteCity(c *gin.Context) {
+	city := c.Query("city")
+	if city == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing city parameter"})
+		return
+	}
+
+	cities, cached, err := h.geo.forget(h.forecasts.cache, city)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"cities": cities, "weather_cache": cached})
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mre/goforecast/internal/weather"
+)
+
+func TestResolveForecast(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	serveUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected upstream request %s", r.URL)
+	})
+	geo := newTestGeocoder(newMemoryCities())
+	geo.allowed = map[string]bool{"berlin": true}
+	h := &handlers{geo: geo, forecasts: newTestWeatherCache(newMemoryCache())}
+
+	r := gin.New()
+	r.GET("/weather/trend", h.trend)
+	r.GET("/weather/extremes", h.extremes)
+	r.GET("/weather/degreedays", h.degreeDays)
+	r.GET("/weather/picnic", h.picnic)
+	r.GET("/history/diff", h.historyDiff)
+	r.POST("/cache/warm", h.warmCity)
+
+	tests := []struct {
+		method, target string
+		want           int
+	}{
+		{http.MethodGet, "/weather/trend", http.StatusBadRequest},
+		{http.MethodGet, "/weather/trend?city=Paris", http.StatusForbidden},
+		{http.MethodGet, "/weather/extremes", http.StatusBadRequest},
+		{http.MethodGet, "/weather/extremes?city=Paris", http.StatusForbidden},
+		{http.MethodGet, "/weather/degreedays", http.StatusBadRequest},
+		{http.MethodGet, "/weather/degreedays?city=Paris", http.StatusForbidden},
+		{http.MethodGet, "/weather/picnic", http.StatusBadRequest},
+		{http.MethodGet, "/weather/picnic?city=Paris", http.StatusForbidden},
+		{http.MethodGet, "/history/diff?from=2024-01-01T00:00:00Z", http.StatusBadRequest},
+		{http.MethodGet, "/history/diff?from=2024-01-01T00:00:00Z&city=Paris", http.StatusForbidden},
+		{http.MethodPost, "/cache/warm", http.StatusBadRequest},
+		{http.MethodPost, "/cache/warm?city=Paris", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.target, nil))
+		if rec.Code != tt.want {
+			t.Errorf("%s %s: got %d, want %d", tt.method, tt.target, rec.Code, tt.want)
+		}
+	}
+}
+
+// berlinResults is a geocoding response for Berlin.
+const berlinResults = `{"results":[{"name":"Berlin","latitude":52.52,"longitude":13.41,"country":"Germany"}]}`
+
+// writeUpcomingForecast answers a forecast request with the current and the
+// next hour, so none of it is filtered out as past.
+func writeUpcomingForecast(w http.ResponseWriter) {
+	hour := time.Now().UTC().Truncate(time.Hour)
+	fmt.Fprintf(w, `{"timezone":"GMT","hourly":{"time":[%q,%q],"temperature_2m":[12.5,13]}}`,
+		hour.Format("2006-01-02T15:04"), hour.Add(time.Hour).Format("2006-01-02T15:04"))
+}
+
+func TestLookupHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	serveUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("name") {
+			w.Write([]byte(berlinResults))
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+		writeUpcomingForecast(w)
+	})
+	h := &handlers{geo: newTestGeocoder(newMemoryCities()), forecasts: newTestWeatherCache(newMemoryCache())}
+	r := gin.New()
+	r.GET("/weather/extremes", h.extremes)
+
+	for _, want := range []string{cacheMiss, cacheHit} {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/weather/extremes?city=Berlin", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("got %d: %s", rec.Code, rec.Body)
+		}
+		if got := rec.Header().Get("X-Cache"); got != want {
+			t.Errorf("X-Cache = %q, want %q", got, want)
+		}
+		latency, err := time.ParseDuration(rec.Header().Get("X-Upstream-Latency"))
+		if err != nil {
+			t.Fatalf("X-Upstream-Latency: %v", err)
+		}
+		if uncached := want == cacheMiss; uncached != (latency >= 20*time.Millisecond) {
+			t.Errorf("%s: X-Upstream-Latency = %s", want, latency)
+		}
+	}
+}
+
+func TestAmbiguousCity(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	serveUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"results":[
+			{"name":"Springfield","admin1":"Missouri","country":"United States","population":169176},
+			{"name":"Springfield","admin1":"Massachusetts","country":"United States","population":155929}
+		]}`))
+	})
+	h := &handlers{geo: newTestGeocoder(newMemoryCities()), forecasts: newTestWeatherCache(newMemoryCache())}
+	r := gin.New()
+	r.GET("/weather/trend", h.trend)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/weather/trend?city=Springfield", nil))
+	if rec.Code != http.StatusMultipleChoices {
+		t.Fatalf("got %d, want 300: %s", rec.Code, rec.Body)
+	}
+	var body struct {
+		Error      string          `json:"error"`
+		Candidates []weather.Place `json:"candidates"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Candidates) != 2 || body.Candidates[0].Admin1 != "Missouri" || body.Candidates[1].Admin1 != "Massachusetts" {
+		t.Errorf("got candidates %+v, want Missouri then Massachusetts", body.Candidates)
+	}
+	if body.Error == "" {
+		t.Error("no error message")
+	}
+}
+
+func TestStatsJSON(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	table := &citiesTable{rows: []cityRow{{name: "Paris"}, {name: "Berlin"}}}
+	h := &handlers{repo: newCityRepo(newTestDB(table), nil)}
+	r := gin.New()
+	r.GET("/stats", adminAuth(Config{}), h.stats)
+
+	tests := []struct {
+		name   string
+		target string
+		accept string
+	}{
+		{"accept header", "/stats", "application/json"},
+		{"format parameter", "/stats?format=json", "text/html"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			req.Header.Set("Accept", tt.accept)
+			req.SetBasicAuth("forecast", "forecast")
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("got %d, want 200: %s", rec.Code, rec.Body)
+			}
+			if got := rec.Header().Get("Content-Type"); got != "application/json; charset=utf-8" {
+				t.Errorf("Content-Type = %q", got)
+			}
+			var cities []string
+			if err := json.Unmarshal(rec.Body.Bytes(), &cities); err != nil {
+				t.Fatalf("body is not a JSON array of names: %v", err)
+			}
+			if want := []string{"Paris", "Berlin"}; !slices.Equal(cities, want) {
+				t.Errorf("got %q, want %q", cities, want)
+			}
+		})
+	}
+
+	t.Run("no cities", func(t *testing.T) {
+		h.repo = newCityRepo(newTestDB(&citiesTable{}), nil)
+		req := httptest.NewRequest(http.MethodGet, "/stats?format=json", nil)
+		req.SetBasicAuth("forecast", "forecast")
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		if rec.Body.String() != "[]" {
+			t.Errorf("got %s, want an empty array", rec.Body)
+		}
+	})
+
+	t.Run("no credentials", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats?format=json", nil))
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("got %d, want 401", rec.Code)
+		}
+	})
+}
+
+func TestDeleteCity(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	berlin := weather.LatLong{Latitude: 52.52, Longitude: 13.41}
+	paris := weather.LatLong{Latitude: 48.85, Longitude: 2.35}
+	table := &citiesTable{rows: []cityRow{{"Berlin", berlin}, {"Paris", paris}}}
+	cache := newMemoryCache()
+	for _, key := range []string{
+		weatherCacheKey(berlin, weather.ForecastParams{}),
+		weatherCacheKey(berlin, weather.ForecastParams{Days: 7}),
+		weatherCacheKey(paris, weather.ForecastParams{}),
+	} {
+		cache.Set(key, []byte(testForecast), time.Minute)
+	}
+	h := &handlers{
+		geo:       newTestGeocoder(newCityRepo(newTestDB(table), nil)),
+		forecasts: newTestWeatherCache(cache),
+	}
+	r := gin.New()
+	r.DELETE("/cache", adminAuth(Config{}), h.deleteCity)
+
+	deleteCity := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodDelete, target, nil)
+		req.SetBasicAuth("forecast", "forecast")
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := deleteCity("/cache?city=Berlin")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if want := `{"cities":1,"weather_cache":2}`; rec.Body.String() != want {
+		t.Errorf("got %s, want %s", rec.Body, want)
+	}
+	if got := table.names(); !slices.Equal(got, []string{"Paris"}) {
+		t.Errorf("cities left: %q, want only Paris", got)
+	}
+	if _, err := cache.Get(weatherCacheKey(berlin, weather.ForecastParams{Days: 7})); !errors.Is(err, ErrCacheMiss) {
+		t.Errorf("Berlin's forecast is still cached: %v", err)
+	}
+	if _, err := cache.Get(weatherCacheKey(paris, weather.ForecastParams{})); err != nil {
+		t.Errorf("Paris's forecast was removed too: %v", err)
+	}
+
+	if rec := deleteCity("/cache?city=Berlin"); rec.Body.String() != `{"cities":0,"weather_cache":0}` {
+		t.Errorf("deleting Berlin again: got %s, want nothing removed", rec.Body)
+	}
+	if rec := deleteCity("/cache"); rec.Code != http.StatusBadRequest {
+		t.Errorf("without a city: got %d, want 400", rec.Code)
+	}
+}
+
+func TestWarmCity(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var geocoded, fetched int
+	serveUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("name") {
+			geocoded++
+			w.Write([]byte(berlinResults))
+			return
+		}
+		fetched++
+		writeUpcomingForecast(w)
+	})
+	cities, cache := newMemoryCities(), newMemoryCache()
+	h := &handlers{geo: newTestGeocoder(cities), forecasts: newTestWeatherCache(cache)}
+	r := gin.New()
+	r.POST("/cache/warm", h.warmCity)
+
+	var body struct {
+		Status         string           `json:"status"`
+		GeocodeCached  bool             `json:"geocode_cached"`
+		ForecastCached bool             `json:"forecast_cached"`
+		Hours          int              `json:"hours"`
+		Warmest        weather.Forecast `json:"warmest"`
+	}
+	warm := func() {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/cache/warm?city=Berlin", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("got %d: %s", rec.Code, rec.Body)
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	warm()
+	if body.Status != "warmed" || body.GeocodeCached || body.ForecastCached || body.Hours != 2 || body.Warmest.Celsius != 13 {
+		t.Errorf("first warm: got %+v, want a newly warmed 2-hour forecast", body)
+	}
+	if _, err := cities.find("Berlin"); err != nil {
+		t.Errorf("Berlin wasn't stored in the cities table: %v", err)
+	}
+	key := weatherCacheKey(weather.LatLong{Latitude: 52.52, Longitude: 13.41}, weather.ForecastParams{})
+	if _, err := cache.Get(key); err != nil {
+		t.Errorf("Berlin's forecast wasn't cached: %v", err)
+	}
+
+	warm()
+	if body.Status != "already_warm" || !body.GeocodeCached || !body.ForecastCached {
+		t.Errorf("second warm: got %+v, want already_warm", body)
+	}
+	if geocoded != 1 || fetched != 1 {
+		t.Errorf("geocoded %d and fetched %d times, want once each", geocoded, fetched)
+	}
+}
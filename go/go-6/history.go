@@ -0,0 +1,105 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/mre/goforecast/internal/weather"
+)
+
+var ErrNoSnapshot = errors.New("no forecast snapshot found")
+
+// forecastHistory keeps every forecast we fetch from open-meteo so we can see
+// how a forecast evolved over time.
+type forecastHistory struct {
+	db *timeoutDB
+	// retention is how long snapshots are kept; older ones for a key are
+	// deleted whenever a new one is added. Zero keeps them forever.
+	retention time.Duration
+}
+
+type snapshot struct {
+	FetchedAt time.Time `db:"fetched_at"`
+	Value     []byte    `db:"value"`
+}
+
+func (h *forecastHistory) Add(key string, raw []byte) error {
+	if _, err := h.db.Exec("INSERT INTO forecast_history (key, fetched_at, value) VALUES ($1, now(), $2)", key, raw); err != nil {
+		return err
+	}
+	if h.retention <= 0 {
+		return nil
+	}
+	_, err := h.db.Exec("DELETE FROM forecast_history WHERE key = $1 AND fetched_at < $2", key, time.Now().Add(-h.retention))
+	return err
+}
+
+// At returns the latest snapshot for key fetched at or before t.
+func (h *forecastHistory) At(key string, t time.Time) (snapshot, error) {
+	var s snapshot
+	err := h.db.Get(&s, "SELECT fetched_at, value FROM forecast_history WHERE key = $1 AND fetched_at <= $2 ORDER BY fetched_at DESC LIMIT 1", key, t)
+	if errors.Is(err, sql.ErrNoRows) {
+		return snapshot{}, ErrNoSnapshot
+	}
+	return s, err
+}
+
+type HourDelta struct {
+	Time  time.Time `json:"time"`
+	From  float64   `json:"from"`
+	To    float64   `json:"to"`
+	Delta float64   `json:"delta"`
+}
+
+// ForecastDiff describes how the hourly temperatures changed between two
+// snapshots. Hours only present in one of them are listed as added or
+// removed.
+type ForecastDiff struct {
+	From    time.Time   `json:"from"`
+	To      time.Time   `json:"to"`
+	Changes []HourDelta `json:"changes"`
+	Added   []time.Time `json:"added"`
+	Removed []time.Time `json:"removed"`
+}
+
+// diffForecasts compares two forecast series hour by hour. Hours are matched
+// by instant, so series in different timezones compare correctly.
+func diffForecasts(from, to []weather.Forecast) ForecastDiff {
+	before := make(map[int64]float64, len(from))
+	for _, f := range from {
+		if !f.Missing {
+			before[f.Time.Unix()] = f.Celsius
+		}
+	}
+
+	diff := ForecastDiff{
+		Changes: []HourDelta{},
+		Added:   []time.Time{},
+		Removed: []time.Time{},
+	}
+	seen := make(map[int64]bool, len(to))
+	for _, f := range to {
+		seen[f.Time.Unix()] = true
+		if f.Missing {
+			continue
+		}
+		old, ok := before[f.Time.Unix()]
+		if !ok {
+			diff.Added = append(diff.Added, f.Time)
+			continue
+		}
+		diff.Changes = append(diff.Changes, HourDelta{
+			Time:  f.Time,
+			From:  old,
+			To:    f.Celsius,
+			Delta: f.Celsius - old,
+		})
+	}
+	for _, f := range from {
+		if !seen[f.Time.Unix()] {
+			diff.Removed = append(diff.Removed, f.Time)
+		}
+	}
+	return diff
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mre/goforecast/internal/weather"
+)
+
+var (
+	berlin, _ = time.LoadLocation("Europe/Berlin")
+	day       = time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// hours returns forecasts for consecutive hours starting at start, one per
+// temperature. NaN stands for a missing hour.
+func hours(start time.Time, temperatures ...float64) []weather.Forecast {
+	forecasts := make([]weather.Forecast, len(temperatures))
+	for i, celsius := range temperatures {
+		forecasts[i] = weather.Forecast{Time: start.Add(time.Duration(i) * time.Hour), Celsius: celsius, Missing: math.IsNaN(celsius)}
+	}
+	return forecasts
+}
+
+func hour(n int) time.Time { return day.Add(time.Duration(n) * time.Hour) }
+
+func TestDiffForecasts(t *testing.T) {
+	nan := math.NaN()
+	tests := []struct {
+		name     string
+		from, to []weather.Forecast
+		want     ForecastDiff
+	}{
+		{
+			name: "warmer by a couple of degrees",
+			from: hours(day, 10, 11, 12),
+			to:   hours(day, 12, 13, 14),
+			want: ForecastDiff{
+				Changes: []HourDelta{
+					{Time: hour(0), From: 10, To: 12, Delta: 2},
+					{Time: hour(1), From: 11, To: 13, Delta: 2},
+					{Time: hour(2), From: 12, To: 14, Delta: 2},
+				},
+				Added:   []time.Time{},
+				Removed: []time.Time{},
+			},
+		},
+		{
+			name: "window moved forward",
+			from: hours(day, 10, 11, 12),
+			to:   hours(hour(1), 11, 10, 9),
+			want: ForecastDiff{
+				Changes: []HourDelta{
+					{Time: hour(1), From: 11, To: 11, Delta: 0},
+					{Time: hour(2), From: 12, To: 10, Delta: -2},
+				},
+				Added:   []time.Time{hour(3)},
+				Removed: []time.Time{hour(0)},
+			},
+		},
+		{
+			name: "same hours in another timezone",
+			from: hours(day, 10, 11),
+			to:   hours(day.In(berlin), 9, 11),
+			want: ForecastDiff{
+				Changes: []HourDelta{
+					{Time: hour(0).In(berlin), From: 10, To: 9, Delta: -1},
+					{Time: hour(1).In(berlin), From: 11, To: 11, Delta: 0},
+				},
+				Added:   []time.Time{},
+				Removed: []time.Time{},
+			},
+		},
+		{
+			name: "missing hours",
+			from: hours(day, 10, nan, 12),
+			to:   hours(day, nan, 13, 14),
+			want: ForecastDiff{
+				Changes: []HourDelta{{Time: hour(2), From: 12, To: 14, Delta: 2}},
+				Added:   []time.Time{hour(1)},
+				Removed: []time.Time{},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diffForecasts(tt.from, tt.to); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
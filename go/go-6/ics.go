@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"time"
+
+	"github.com/mre/goforecast/internal/weather"
+)
+
+// icsEscaper escapes the characters RFC 5545 reserves in TEXT values.
+var icsEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`)
+
+// dailyCalendar renders days as an iCalendar feed with one all-day event per
+// day summarising its high and low.
+func dailyCalendar(place string, days []DaySummary, format weather.TemperatureFormat, now time.Time) []byte {
+	var buf bytes.Buffer
+	line := func(s string) { writeICSLine(&buf, s) }
+
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//goforecast//weather//EN")
+	line("CALSCALE:GREGORIAN")
+	line("X-WR-CALNAME:" + icsEscaper.Replace("Weather for "+place))
+	stamp := now.UTC().Format("20060102T150405Z")
+	// UIDs must stay stable across refreshes so calendar apps update events
+	// in place instead of duplicating them.
+	h := fnv.New32a()
+	h.Write([]byte(place))
+	placeID := h.Sum32()
+	for _, day := range days {
+		date := day.Date.Format("20060102")
+		line("BEGIN:VEVENT")
+		line(fmt.Sprintf("UID:%s-%08x@goforecast", date, placeID))
+		line("DTSTAMP:" + stamp)
+		line("DTSTART;VALUE=DATE:" + date)
+		line("DTEND;VALUE=DATE:" + day.Date.AddDate(0, 0, 1).Format("20060102"))
+		line("SUMMARY:" + icsEscaper.Replace(fmt.Sprintf("High %s, low %s", format.Format(day.Max), format.Format(day.Min))))
+		line("DESCRIPTION:" + icsEscaper.Replace(fmt.Sprintf("%s: average %s over %d forecast hours", place, format.Format(day.Avg), day.Hours)))
+		line("TRANSP:TRANSPARENT")
+		line("END:VEVENT")
+	}
+	line("END:VCALENDAR")
+	return buf.Bytes()
+}
+
+// writeICSLine writes s terminated by CRLF, folding it into continuation
+// lines so that none exceeds the 75 octets RFC 5545 allows.
+func writeICSLine(buf *bytes.Buffer, s string) {
+	limit := 75
+	for len(s) > limit {
+		n := limit
+		for s[n]&0xC0 == 0x80 {
+			// Don't split a UTF-8 sequence.
+			n--
+		}
+		buf.WriteString(s[:n])
+		buf.WriteString("\r\n ")
+		s = s[n:]
+		// The leading space of a continuation line counts towards the limit.
+		limit = 74
+	}
+	buf.WriteString(s)
+	buf.WriteString("\r\n")
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mre/goforecast/internal/weather"
+)
+
+// parseICS unfolds an iCalendar document and returns its events, each as a
+// map from property name, parameters included, to value.
+func parseICS(t *testing.T, doc string) []map[string]string {
+	t.Helper()
+	if !strings.HasSuffix(doc, "\r\n") {
+		t.Fatal("document doesn't end with CRLF")
+	}
+	var events []map[string]string
+	var event map[string]string
+	for _, line := range strings.Split(strings.ReplaceAll(strings.TrimSuffix(doc, "\r\n"), "\r\n ", ""), "\r\n") {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			t.Fatalf("line without a value: %q", line)
+		}
+		switch {
+		case line == "BEGIN:VEVENT":
+			event = make(map[string]string)
+		case line == "END:VEVENT":
+			events = append(events, event)
+			event = nil
+		case event != nil:
+			event[name] = value
+		}
+	}
+	return events
+}
+
+func TestDailyCalendar(t *testing.T) {
+	temperatures := make([]float64, 3*24)
+	for i := range temperatures {
+		temperatures[i] = float64(10 + i%24/2)
+	}
+	days := aggregateDaily(hours(day, temperatures...))
+	place := "Frankfurt am Main, Hessen, Deutschland; a name long enough to fold, with ümlauts"
+	doc := string(dailyCalendar(place, days, weather.TemperatureFormat{Decimals: 0}, day))
+
+	for _, line := range strings.Split(doc, "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line of %d octets: %q", len(line), line)
+		}
+	}
+	if !strings.HasPrefix(doc, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n") || !strings.HasSuffix(doc, "END:VCALENDAR\r\n") {
+		t.Errorf("not a VCALENDAR:\n%s", doc)
+	}
+
+	events := parseICS(t, doc)
+	if len(events) != len(days) || len(days) != 3 {
+		t.Fatalf("got %d events for %d forecast days, want 3", len(events), len(days))
+	}
+	uids := make(map[string]bool)
+	for i, event := range events {
+		date := day.AddDate(0, 0, i)
+		if got, want := event["DTSTART;VALUE=DATE"], date.Format("20060102"); got != want {
+			t.Errorf("event %d starts %s, want %s", i, got, want)
+		}
+		if got, want := event["DTEND;VALUE=DATE"], date.AddDate(0, 0, 1).Format("20060102"); got != want {
+			t.Errorf("event %d ends %s, want %s", i, got, want)
+		}
+		if got, want := event["SUMMARY"], `High 21°C\, low 10°C`; got != want {
+			t.Errorf("event %d summary %q, want %q", i, got, want)
+		}
+		if !strings.HasPrefix(event["DESCRIPTION"], `Frankfurt am Main\, Hessen\, Deutschland\; a name`) {
+			t.Errorf("event %d description not escaped: %q", i, event["DESCRIPTION"])
+		}
+		uids[event["UID"]] = true
+	}
+	if len(uids) != len(events) {
+		t.Errorf("events share UIDs: %v", uids)
+	}
+
+	// Refreshing the feed later must keep the UIDs, so calendars update the
+	// events instead of adding new ones.
+	again := parseICS(t, string(dailyCalendar(place, days, weather.TemperatureFormat{Decimals: 0}, day.Add(time.Hour))))
+	for i := range events {
+		if events[i]["UID"] != again[i]["UID"] {
+			t.Errorf("event %d UID changed from %s to %s", i, events[i]["UID"], again[i]["UID"])
+		}
+	}
+}
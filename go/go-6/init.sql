@@ -6,3 +6,33 @@ CREATE TABLE IF NOT EXISTS cities (
 );
 
 CREATE INDEX IF NOT EXISTS cities_name_idx ON cities (name);
+
+ALTER TABLE cities ADD COLUMN IF NOT EXISTS geocoded_at TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE cities ADD COLUMN IF NOT EXISTS last_requested_at TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE cities ADD COLUMN IF NOT EXISTS place_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE cities ADD COLUMN IF NOT EXISTS admin1 TEXT NOT NULL DEFAULT '';
+ALTER TABLE cities ADD COLUMN IF NOT EXISTS country TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS cities_last_requested_at_idx ON cities (last_requested_at);
+
+-- alias is lower case; city is the name it stands for, e.g. nyc -> New York.
+CREATE TABLE IF NOT EXISTS city_aliases (
+    alias TEXT PRIMARY KEY,
+    city TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS weather_cache (
+    key TEXT PRIMARY KEY,
+    value BYTEA NOT NULL,
+    fetched_at TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS forecast_history (
+    id SERIAL PRIMARY KEY,
+    key TEXT NOT NULL,
+    fetched_at TIMESTAMPTZ NOT NULL,
+    value BYTEA NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS forecast_history_key_idx ON forecast_history (key, fetched_at);
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/mre/goforecast/internal/weather"
+)
+
+// ErrNoClientLocation is returned when a client's address can't be placed,
+// e.g. because it is private or the provider doesn't know it.
+var ErrNoClientLocation = errors.New("could not determine your location, please pass ?city=")
+
+// ipLocator looks up a client's approximate location with an IP geolocation
+// service. endpoint contains "{ip}" where the address goes, and must answer
+// with JSON in the shape ipapi.co uses.
+type ipLocator struct {
+	endpoint string
+	client   *http.Client
+}
+
+type ipLocation struct {
+	Latitude    *float64 `json:"latitude"`
+	Longitude   *float64 `json:"longitude"`
+	City        string   `json:"city"`
+	Region      string   `json:"region"`
+	CountryName string   `json:"country_name"`
+}
+
+// locate resolves ip to the place the provider thinks it is in.
+func (l *ipLocator) locate(ctx context.Context, ip string) (*weather.Place, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil || addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() || addr.IsUnspecified() {
+		return nil, ErrNoClientLocation
+	}
+
+	endpoint := strings.ReplaceAll(l.endpoint, "{ip}", addr.String())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error making request to IP geolocation service: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("IP geolocation service returned %s", resp.Status)
+	}
+
+	var location ipLocation
+	if err := json.NewDecoder(resp.Body).Decode(&location); err != nil {
+		return nil, fmt.Errorf("error decoding IP geolocation response: %w", err)
+	}
+	if location.Latitude == nil || location.Longitude == nil {
+		return nil, ErrNoClientLocation
+	}
+	return &weather.Place{
+		LatLong: weather.LatLong{Latitude: *location.Latitude, Longitude: *location.Longitude},
+		Name:    location.City,
+		Admin1:  location.Region,
+		Country: location.CountryName,
+	}, nil
+}
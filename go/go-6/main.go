@@ -1,187 +1,261 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
+	"flag"
 	"fmt"
-	"io"
+	"log/slog"
+	"maps"
+	"math"
 	"net/http"
-	"net/url"
 	"os"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
+	"github.com/mre/goforecast/internal/weather"
+	"golang.org/x/time/rate"
 )
 
-type GeoResponse struct {
-	Results []LatLong `json:"results"`
-}
-
-type LatLong struct {
-	Latitude  float64 `json:"latitude"`
-	Longitude float64 `json:"longitude"`
-}
-
-type WeatherResponse struct {
-	Latitude  float64 `json:"latitude"`
-	Longitude float64 `json:"longitude"`
-	Timezone  string  `json:"timezone"`
-	Hourly    struct {
-		Time          []string  `json:"time"`
-		Temperature2m []float64 `json:"temperature_2m"`
-	} `json:"hourly"`
-}
+// minElevation and maxElevation bound ?elevation, in metres: from the shore
+// of the Dead Sea to a little above Everest.
+const (
+	minElevation = -500
+	maxElevation = 9000
+)
 
-type WeatherDisplay struct {
-	City      string
-	Forecasts []Forecast
-}
+var (
+	// Postal codes vary too much between countries to validate strictly;
+	// this only rules out obvious garbage.
+	postalCodePattern  = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9 -]{1,9}$`)
+	countryCodePattern = regexp.MustCompile(`^[A-Za-z]{2}$`)
+)
 
-type Forecast struct {
-	Date        string
-	Temperature string
+// errorStatus maps errors from the lookup pipeline to an HTTP status code.
+func errorStatus(err error) int {
+	var statusErr *weather.StatusError
+	switch {
+	case errors.Is(err, ErrNoSnapshot), errors.Is(err, weather.ErrNoForecastData), errors.Is(err, weather.ErrNoResults),
+		errors.Is(err, ErrTooFewForecasts):
+		return http.StatusNotFound
+	case errors.Is(err, ErrCityNotAllowed):
+		return http.StatusForbidden
+	case errors.Is(err, ErrQueueFull), errors.Is(err, ErrTooStale), errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, weather.ErrUpstreamUnreachable):
+		return http.StatusServiceUnavailable
+	case errors.As(err, &statusErr):
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
 }
 
-func getLastCities(db *sqlx.DB) ([]string, error) {
-	var cities []string
-	err := db.Select(&cities, "SELECT name FROM cities ORDER BY id DESC LIMIT 10")
-	if err != nil {
-		return nil, err
+// parseTimeParam parses an RFC 3339 query parameter, returning fallback when
+// it is empty.
+func parseTimeParam(value string, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
 	}
-	return cities, nil
+	return time.Parse(time.RFC3339, value)
 }
 
-func insertCity(db *sqlx.DB, name string, latLong LatLong) error {
-	_, err := db.Exec("INSERT INTO cities (name, lat, long) VALUES ($1, $2, $3)", name, latLong.Latitude, latLong.Longitude)
-	return err
+// setLookupHeaders reports how the forecast behind a response was obtained:
+// X-Cache is HIT, MISS or STALE and X-Upstream-Latency how long the request
+// waited on open-meteo. Age is how long ago the forecast was fetched and
+// Cache-Control's max-age how long it stays fresh, keeping a private
+// directive set earlier.
+func setLookupHeaders(c *gin.Context, info lookupInfo) {
+	if info.Cache != "" {
+		c.Header("X-Cache", info.Cache)
+	}
+	c.Header("X-Upstream-Latency", info.Upstream.Round(time.Millisecond).String())
+	if info.FetchedAt.IsZero() {
+		return
+	}
+	age := max(0, time.Since(info.FetchedAt))
+	maxAge := max(0, time.Until(info.ExpiresAt))
+	c.Header("Age", strconv.FormatInt(int64(age/time.Second), 10))
+	cacheControl := "max-age=" + strconv.FormatInt(int64(maxAge/time.Second), 10)
+	if strings.Contains(c.Writer.Header().Get("Cache-Control"), "private") {
+		cacheControl = "private, " + cacheControl
+	}
+	c.Header("Cache-Control", cacheControl)
 }
 
-func extractWeatherData(city string, rawWeather string) (WeatherDisplay, error) {
-	var weatherResponse WeatherResponse
-	if err := json.Unmarshal([]byte(rawWeather), &weatherResponse); err != nil {
-		return WeatherDisplay{}, fmt.Errorf("error decoding weather response: %w", err)
+// parseThreshold parses a temperature given in unit and returns it in
+// Celsius, or nil if value is empty.
+func parseThreshold(value string, unit weather.Unit) (*float64, error) {
+	if value == "" {
+		return nil, nil
 	}
-
-	var forecasts []Forecast
-	for i, t := range weatherResponse.Hourly.Time {
-		date, err := time.Parse("2006-01-02T15:04", t)
-		if err != nil {
-			return WeatherDisplay{}, err
-		}
-		forecast := Forecast{
-			Date:        date.Format("Mon, 2 Jan 15:04"),
-			Temperature: fmt.Sprintf("%.1f°C", weatherResponse.Hourly.Temperature2m[i]),
-		}
-		forecasts = append(forecasts, forecast)
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil, fmt.Errorf("%q is not a temperature", value)
 	}
-	return WeatherDisplay{
-		City:      city,
-		Forecasts: forecasts,
-	}, nil
+	celsius := unit.ToCelsius(v)
+	return &celsius, nil
 }
 
-func fetchLatLong(city string) (*LatLong, error) {
-	endpoint := fmt.Sprintf("https://geocoding-api.open-meteo.com/v1/search?name=%s&count=1&language=en&format=json", url.QueryEscape(city))
-	resp, err := http.Get(endpoint)
+func main() {
+	runSelftest := flag.Bool("selftest", false, "run the forecast pipeline once against open-meteo and exit")
+	selftestCity := flag.String("selftest-city", "Berlin", "city to look up with -selftest")
+	flag.Parse()
+
+	cfg, err := loadConfig()
 	if err != nil {
-		return nil, fmt.Errorf("error making request to Geo API: %w", err)
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
 	}
-	defer resp.Body.Close()
-
-	var response GeoResponse
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return nil, fmt.Errorf("error decoding response: %w", err)
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: cfg.LogLevel})))
+	if cfg.LogLevel > slog.LevelDebug {
+		// Gin's debug mode prints every route and warning at startup.
+		gin.SetMode(gin.ReleaseMode)
 	}
 
-	if len(response.Results) < 1 {
-		return nil, errors.New("no results found")
+	weather.Client = weather.NewClient(cfg.ProxyURL, cfg.UpstreamTimeout)
+	if cfg.GeocodingURL != "" {
+		weather.GeocodingEndpoint = cfg.GeocodingURL
 	}
-
-	return &response.Results[0], nil
-}
-
-func getLatLong(db *sqlx.DB, name string) (*LatLong, error) {
-	var latLong *LatLong
-	err := db.Get(&latLong, "SELECT lat, long FROM cities WHERE name = $1", name)
-	if err == nil {
-		return latLong, nil
+	if cfg.ForecastURL != "" {
+		weather.ForecastEndpoint = cfg.ForecastURL
 	}
 
-	latLong, err = fetchLatLong(name)
-	if err != nil {
-		return nil, err
+	if *runSelftest {
+		if err := selftest(os.Stdout, *selftestCity, cfg.extractOptions()); err != nil {
+			fmt.Fprintln(os.Stderr, "selftest failed:", err)
+			os.Exit(1)
+		}
+		return
 	}
 
-	err = insertCity(db, name, *latLong)
+	tlsConfig, err := loadTLSConfig(cfg.TLSCertFile, cfg.TLSKeyFile)
 	if err != nil {
-		return nil, err
+		slog.Error("invalid TLS configuration", "error", err)
+		os.Exit(1)
 	}
 
-	return latLong, nil
-}
-
-func getWeather(latLong LatLong) (string, error) {
-	endpoint := fmt.Sprintf("https://api.open-meteo.com/v1/forecast?latitude=%.6f&longitude=%.6f&hourly=temperature_2m&timezone=auto&forecast_days=3", latLong.Latitude, latLong.Longitude)
-	resp, err := http.Get(endpoint)
+	r := gin.Default()
+	if err := r.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		slog.Error("invalid trusted proxies", "error", err)
+		os.Exit(1)
+	}
+	r.Use(
+		requestID(cfg.RequestIDHeader),
+		cors(cfg.CORSAllowedOrigins, cfg.CORSAllowedMethods, cfg.CORSAllowedHeaders),
+		limitBody(cfg.MaxBodyBytes),
+		requireJSON(),
+		headResponses(),
+	)
+	tmpl, err := loadTemplates(cfg.TemplatesDir)
 	if err != nil {
-		return "", fmt.Errorf("error making request to Weather API: %w", err)
+		slog.Error("could not load templates", "error", err)
+		os.Exit(1)
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	conn, err := connectDB(withStatementTimeout(cfg.DatabaseURL, cfg.DBStatementTimeout), cfg.DBConnectTimeout)
 	if err != nil {
-		return "", fmt.Errorf("error reading response body: %w", err)
+		slog.Error("could not connect to database", "error", err)
+		os.Exit(1)
 	}
+	db := &timeoutDB{DB: conn, timeout: cfg.DBStatementTimeout}
+	var replica *timeoutDB
+	if cfg.ReadDatabaseURL != "" {
+		conn, err := connectDB(withStatementTimeout(cfg.ReadDatabaseURL, cfg.DBStatementTimeout), cfg.DBConnectTimeout)
+		if err != nil {
+			slog.Error("could not connect to read replica", "error", err)
+			os.Exit(1)
+		}
+		replica = &timeoutDB{DB: conn, timeout: cfg.DBStatementTimeout}
+	}
+	repo := newCityRepo(db, replica)
+	queue := newUpstreamQueue(cfg.UpstreamWorkers, cfg.UpstreamQueueSize, &retrier{
+		retries: cfg.UpstreamRetries,
+		budget:  newRetryBudget(cfg.RetryBudgetRatio),
+	})
 
-	return string(body), nil
-}
-
-func main() {
-	r := gin.Default()
-	// Assuming template.html is inside a folder named "views"
-	r.LoadHTMLGlob("views/*")
+	// background is drained on shutdown.
+	background := &backgroundTasks{}
+	aliases := maps.Clone(cfg.CityAliases)
+	if stored, err := repo.aliases(); err != nil {
+		slog.Warn("could not load city aliases from the database", "error", err)
+	} else {
+		maps.Copy(aliases, stored)
+	}
+	geo := &geocoder{
+		cities: repo,
+		queue:  queue,
+		writer: newDBWriter(cfg.DBWriteWorkers, cfg.DBWriteQueueSize, background),
+		ttl:    cfg.GeoCacheTTL,
 
-	fmt.Println(os.Getenv("DATABASE_URL"))
-	db := sqlx.MustConnect("postgres", os.Getenv("DATABASE_URL"))
+		maxCities: cfg.MaxCities,
+		misses:    newMissCache(cfg.GeoMissTTL),
+		aliases:   aliases,
+		allowed:   cfg.AllowedCities,
+	}
+	forecasts := &weatherCache{
+		queue:    queue,
+		cache:    newDBCache(db, repo.replica, cfg.CachePrefix),
+		ttl:      cfg.CacheTTL,
+		maxStale: cfg.MaxStale,
+		history:  &forecastHistory{db: db, retention: cfg.HistoryRetention},
+		beta:     cfg.EarlyRefreshBeta,
 
-	r.GET("/", func(c *gin.Context) {
-		c.HTML(http.StatusOK, "index.html", nil)
-	})
+		refreshWindow: cfg.RefreshWindow,
+		softTimeout:   cfg.SoftTimeout,
+		background:    background,
+	}
 
-	r.GET("/weather", func(c *gin.Context) {
-		city := c.Query("city")
-		latlong, err := getLatLong(db, city)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
+	limiter := newIPRateLimiter(rate.Limit(cfg.RateLimit), cfg.RateBurst, 5*time.Minute).Middleware()
+	cacheResponses := newResponseCache(cfg.ResponseCacheTTL, cfg.ResponseCacheSize).Middleware()
 
-		weather, err := getWeather(*latlong)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-
-		weatherDisplay, err := extractWeatherData(city, weather)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-		c.HTML(http.StatusOK, "weather.html", weatherDisplay)
-	})
+	// warmed is closed once the cache warmer has finished its first pass;
+	// until then the instance reports itself as not ready.
+	warmed := make(chan struct{})
+	if len(cfg.WarmCities) > 0 {
+		background.Go(func() {
+			warmCache(geo, forecasts, cfg.WarmCities, cfg.WarmConcurrency)
+			close(warmed)
+		})
+	} else {
+		close(warmed)
+	}
 
-	r.GET("/stats", gin.BasicAuth(gin.Accounts{
-		"forecast": "forecast",
-	}), func(c *gin.Context) {
-		cities, err := getLastCities(db)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-		c.HTML(http.StatusOK, "stats.html", cities)
-	})
+	var alerter Alerter
+	if cfg.AlertsURL != "" {
+		alerter = &httpAlerter{endpoint: cfg.AlertsURL, client: weather.Client}
+	}
+	var locator *ipLocator
+	if cfg.IPGeolocationURL != "" {
+		locator = &ipLocator{endpoint: cfg.IPGeolocationURL, client: weather.Client}
+	}
+	h := &handlers{
+		cfg:       cfg,
+		html:      htmlRenderer{tmpl},
+		formats:   formatNegotiator{priority: cfg.FormatPriority},
+		db:        db,
+		replica:   replica,
+		repo:      repo,
+		queue:     queue,
+		geo:       geo,
+		forecasts: forecasts,
+		alerter:   alerter,
+		locator:   locator,
+		warmed:    warmed,
+	}
+	h.register(r, limiter, cacheResponses)
 
-	r.Run()
+	// Listen where r.Run would have.
+	addr := ":" + getEnv("PORT", "8080")
+	handler := withTimeouts(r, cfg.RequestTimeout, cfg.RouteTimeouts, "/weather", "/weather/postal")
+	if err := runServer(handler, addr, tlsConfig, cfg.ShutdownTimeout); err != nil {
+		slog.Error("server failed", "error", err)
+		os.Exit(1)
+	}
+	if !background.Drain(cfg.DrainTimeout) {
+		slog.Warn("background work still running, exiting anyway")
+	}
 }
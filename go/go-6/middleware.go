@@ -0,0 +1,215 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requireJSON rejects POST, PUT and PATCH requests whose body isn't declared
+// as application/json, so clients get a clear 415 instead of a confusing bind
+// error further down. Requests without a body are let through.
+func requireJSON() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch:
+		default:
+			c.Next()
+			return
+		}
+
+		if c.Request.ContentLength != 0 && c.ContentType() != gin.MIMEJSON {
+			c.AbortWithStatusJSON(http.StatusUnsupportedMediaType, gin.H{
+				"error": fmt.Sprintf("unsupported Content-Type %q, request body must be %s", c.ContentType(), gin.MIMEJSON),
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// requestIDKey is the gin context key holding the current request's ID.
+const requestIDKey = "requestID"
+
+// maxRequestIDLength bounds the incoming IDs requestID accepts.
+const maxRequestIDLength = 128
+
+// requestID tags every request with an ID, stored under requestIDKey and
+// echoed back in header. An ID already set in that header by an upstream
+// proxy is reused if it looks valid; otherwise a random one is generated.
+func requestID(header string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(header)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		c.Set(requestIDKey, id)
+		c.Header(header, id)
+		c.Next()
+	}
+}
+
+// validRequestID accepts 1 to maxRequestIDLength letters, digits and "-_.:",
+// which covers UUIDs and the usual tracing formats without letting clients
+// smuggle arbitrary text into logs and headers.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', strings.ContainsRune("-_.:", r):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("could not generate request ID: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+// limitBody caps request bodies at maxBytes. Requests that declare a larger
+// Content-Length are rejected up front with a 413; bodies of unknown length
+// are wrapped in http.MaxBytesReader so reading past the limit fails.
+func limitBody(maxBytes int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.ContentLength > maxBytes {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{
+				"error": fmt.Sprintf("request body exceeds %d bytes", maxBytes),
+			})
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes)
+		c.Next()
+	}
+}
+
+// cors adds CORS headers for requests from one of the allowed origins ("*"
+// allows any) and answers preflight requests directly.
+func cors(origins, methods, headers []string) gin.HandlerFunc {
+	allowed := make(map[string]bool, len(origins))
+	for _, origin := range origins {
+		allowed[origin] = true
+	}
+	allowMethods := strings.Join(methods, ", ")
+	allowHeaders := strings.Join(headers, ", ")
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+		c.Writer.Header().Add("Vary", "Origin")
+		if !allowed[origin] && !allowed["*"] {
+			c.Next()
+			return
+		}
+
+		c.Header("Access-Control-Allow-Origin", origin)
+		if c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != "" {
+			c.Header("Access-Control-Allow-Methods", allowMethods)
+			c.Header("Access-Control-Allow-Headers", allowHeaders)
+			c.Header("Access-Control-Max-Age", "600")
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		c.Next()
+	}
+}
+
+// headResponses lets GET handlers answer HEAD requests: the handler runs as
+// usual, but its body is only measured so Content-Length matches what a GET
+// would have returned.
+func headResponses() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method != http.MethodHead {
+			c.Next()
+			return
+		}
+
+		w := &headWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+		c.Writer = w.ResponseWriter
+
+		c.Header("Content-Length", strconv.Itoa(w.size))
+		c.Writer.WriteHeaderNow()
+	}
+}
+
+// headWriter discards the body and holds back the headers until the handler
+// is done and the final size is known.
+type headWriter struct {
+	gin.ResponseWriter
+	size int
+}
+
+func (w *headWriter) Write(b []byte) (int, error) {
+	w.size += len(b)
+	return len(b), nil
+}
+
+func (w *headWriter) WriteString(s string) (int, error) {
+	w.size += len(s)
+	return len(s), nil
+}
+
+func (w *headWriter) WriteHeaderNow() {}
+
+// timeoutBody is sent with the 503 when a request runs out of time.
+const timeoutBody = `{"error":"request timed out"}`
+
+// withTimeouts limits how long each request may take, answering 503 once its
+// deadline passes. The deadline comes from the longest path prefix in
+// overrides that matches, or fallback otherwise; zero means no limit.
+// Streamed responses (?stream=true on one of the streaming paths) are
+// exempt, since http.TimeoutHandler buffers the whole response and can't
+// flush.
+func withTimeouts(h http.Handler, fallback time.Duration, overrides map[string]time.Duration, streaming ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeout := routeTimeout(r.URL.Path, fallback, overrides)
+		if timeout <= 0 || (r.URL.Query().Get("stream") == "true" && slices.Contains(streaming, r.URL.Path)) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", gin.MIMEJSON)
+		http.TimeoutHandler(h, timeout, timeoutBody).ServeHTTP(w, r)
+	})
+}
+
+// routeTimeout returns the timeout for path. A prefix matches the path itself
+// and the paths below it, so /weather covers /weather/trend but not
+// /weatherstation.
+func routeTimeout(path string, fallback time.Duration, overrides map[string]time.Duration) time.Duration {
+	timeout, longest := fallback, -1
+	for prefix, d := range overrides {
+		if underPrefix(path, prefix) && len(prefix) > longest {
+			timeout, longest = d, len(prefix)
+		}
+	}
+	return timeout
+}
+
+func underPrefix(path, prefix string) bool {
+	if path == prefix {
+		return true
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return strings.HasPrefix(path, prefix)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequireJSON(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(requireJSON())
+	r.Any("/cache/warm", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	tests := []struct {
+		method      string
+		contentType string
+		body        string
+		want        int
+	}{
+		{http.MethodPost, "application/json", `{}`, http.StatusOK},
+		{http.MethodPost, "application/json; charset=utf-8", `{}`, http.StatusOK},
+		{http.MethodPut, "text/plain", "city=Berlin", http.StatusUnsupportedMediaType},
+		{http.MethodPatch, "application/x-www-form-urlencoded", "city=Berlin", http.StatusUnsupportedMediaType},
+		{http.MethodPost, "", "city=Berlin", http.StatusUnsupportedMediaType},
+		{http.MethodPost, "", "", http.StatusOK},
+		{http.MethodGet, "text/plain", "city=Berlin", http.StatusOK},
+		{http.MethodDelete, "text/plain", "city=Berlin", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "/cache/warm", strings.NewReader(tt.body))
+		if tt.contentType != "" {
+			req.Header.Set("Content-Type", tt.contentType)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s with %q body of type %q: got %d, want %d", tt.method, tt.body, tt.contentType, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestLimitBody(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(limitBody(16))
+	r.POST("/cache/warm", func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.Status(http.StatusRequestEntityTooLarge)
+			return
+		}
+		c.String(http.StatusOK, "%d", len(body))
+	})
+
+	tests := []struct {
+		name string
+		body string
+		// unknownLength hides the Content-Length, as with a chunked body.
+		unknownLength bool
+		want          int
+	}{
+		{"within the limit", `{"city":"Paris"}`, false, http.StatusOK},
+		{"declared too large", strings.Repeat("x", 17), false, http.StatusRequestEntityTooLarge},
+		{"chunked too large", strings.Repeat("x", 1<<20), true, http.StatusRequestEntityTooLarge},
+		{"chunked within the limit", "small", true, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/cache/warm", strings.NewReader(tt.body))
+			if tt.unknownLength {
+				req.ContentLength = -1
+			}
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("got %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithTimeouts(t *testing.T) {
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := withTimeouts(slow, 10*time.Millisecond, nil, "/weather", "/weather/postal")
+	tests := []struct {
+		target string
+		want   int
+	}{
+		{"/weather", http.StatusServiceUnavailable},
+		{"/weather?stream=true", http.StatusOK},
+		{"/weather/postal?code=10115&stream=true", http.StatusOK},
+		{"/weather/postal?stream=false", http.StatusServiceUnavailable},
+		{"/weather/trend?stream=true", http.StatusServiceUnavailable},
+		{"/stats?stream=true", http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+		if rec.Code != tt.want {
+			t.Errorf("GET %s: got %d, want %d", tt.target, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestRouteTimeout(t *testing.T) {
+	overrides := map[string]time.Duration{
+		"/weather":       5 * time.Second,
+		"/weather/batch": 30 * time.Second,
+		"/cache/":        time.Minute,
+	}
+	tests := []struct {
+		path string
+		want time.Duration
+	}{
+		{"/weather", 5 * time.Second},
+		{"/weather/trend", 5 * time.Second},
+		{"/weather/batch", 30 * time.Second},
+		{"/weather/batch/x", 30 * time.Second},
+		{"/weather/batches", 5 * time.Second},
+		{"/weatherstation", 10 * time.Second},
+		{"/cache/warm", time.Minute},
+		{"/cache", 10 * time.Second},
+		{"/stats", 10 * time.Second},
+		{"/", 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := routeTimeout(tt.path, 10*time.Second, overrides); got != tt.want {
+			t.Errorf("routeTimeout(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestCORS(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := func(origins ...string) *gin.Engine {
+		r := gin.New()
+		r.Use(cors(origins, []string{"GET", "POST"}, []string{"Content-Type", "Authorization"}))
+		r.Any("/weather", func(c *gin.Context) { c.Status(http.StatusOK) })
+		return r
+	}
+	app := handler("https://app.example.com")
+
+	tests := []struct {
+		name        string
+		handler     *gin.Engine
+		method      string
+		origin      string
+		preflight   bool
+		want        int
+		wantOrigin  string
+		wantMethods string
+	}{
+		{"no origin", app, http.MethodGet, "", false, http.StatusOK, "", ""},
+		{"allowed origin", app, http.MethodGet, "https://app.example.com", false, http.StatusOK, "https://app.example.com", ""},
+		{"other origin", app, http.MethodGet, "https://evil.example.com", false, http.StatusOK, "", ""},
+		{"preflight", app, http.MethodOptions, "https://app.example.com", true, http.StatusNoContent, "https://app.example.com", "GET, POST"},
+		{"preflight from another origin", app, http.MethodOptions, "https://evil.example.com", true, http.StatusOK, "", ""},
+		{"plain OPTIONS", app, http.MethodOptions, "https://app.example.com", false, http.StatusOK, "https://app.example.com", ""},
+		{"wildcard echoes the origin", handler("*"), http.MethodGet, "https://any.example.com", false, http.StatusOK, "https://any.example.com", ""},
+		{"nothing allowed", handler(), http.MethodGet, "https://app.example.com", false, http.StatusOK, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/weather", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.preflight {
+				req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			}
+			rec := httptest.NewRecorder()
+			tt.handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("got status %d, want %d", rec.Code, tt.want)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("got Access-Control-Allow-Origin %q, want %q", got, tt.wantOrigin)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Methods"); got != tt.wantMethods {
+				t.Errorf("got Access-Control-Allow-Methods %q, want %q", got, tt.wantMethods)
+			}
+			if wantVary := tt.origin != ""; (rec.Header().Get("Vary") == "Origin") != wantVary {
+				t.Errorf("got Vary %q, want Origin: %t", rec.Header().Get("Vary"), wantVary)
+			}
+		})
+	}
+}
+
+func TestHeadResponses(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(headResponses())
+	methods := []string{http.MethodGet, http.MethodHead}
+	r.Match(methods, "/healthz", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"status": "ok"}) })
+	r.Match(methods, "/weather", func(c *gin.Context) { c.JSON(http.StatusNotFound, gin.H{"error": "no results found"}) })
+	r.Match(methods, "/empty", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	for _, target := range []string{"/healthz", "/weather", "/empty"} {
+		get, head := httptest.NewRecorder(), httptest.NewRecorder()
+		r.ServeHTTP(get, httptest.NewRequest(http.MethodGet, target, nil))
+		r.ServeHTTP(head, httptest.NewRequest(http.MethodHead, target, nil))
+
+		if head.Code != get.Code {
+			t.Errorf("HEAD %s: got status %d, GET got %d", target, head.Code, get.Code)
+		}
+		if head.Body.Len() != 0 {
+			t.Errorf("HEAD %s: got body %q, want none", target, head.Body)
+		}
+		if got, want := head.Header().Get("Content-Length"), strconv.Itoa(get.Body.Len()); got != want {
+			t.Errorf("HEAD %s: got Content-Length %s, want %s", target, got, want)
+		}
+		if got, want := head.Header().Get("Content-Type"), get.Header().Get("Content-Type"); got != want {
+			t.Errorf("HEAD %s: got Content-Type %q, want %q", target, got, want)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Response formats. Which of them an endpoint offers is up to the endpoint;
+// formats without a media type, like series, can only be asked for with
+// ?format=.
+const (
+	formatJSON   = "json"
+	formatHTML   = "html"
+	formatCSV    = "csv"
+	formatICS    = "ics"
+	formatSeries = "series"
+)
+
+var formatMediaTypes = map[string]string{
+	formatJSON: gin.MIMEJSON,
+	formatHTML: gin.MIMEHTML,
+	formatCSV:  "text/csv",
+	formatICS:  "text/calendar",
+}
+
+// negotiableFormats are the formats FORMAT_PRIORITY may rank.
+var negotiableFormats = []string{formatJSON, formatHTML, formatCSV}
+
+// formatNegotiator picks the response format for a request. An explicit
+// ?format= wins; otherwise the Accept header decides, with ties and
+// wildcards going to the format listed first in priority. Clients whose
+// Accept header matches nothing on offer get the highest-priority format
+// rather than an error, since browsers and tools send all sorts of headers.
+type formatNegotiator struct {
+	priority []string
+}
+
+// pick returns the format to answer c with, out of offered. It fails only if
+// ?format= names a format the endpoint doesn't offer.
+func (n formatNegotiator) pick(c *gin.Context, offered ...string) (string, error) {
+	offered = n.rank(offered)
+	if format := c.Query("format"); format != "" {
+		if !slices.Contains(offered, format) {
+			return "", fmt.Errorf("unsupported format %q, want one of %s", format, strings.Join(offered, ", "))
+		}
+		return format, nil
+	}
+
+	best, bestQ := offered[0], 0.0
+	for _, format := range offered {
+		if q := acceptQuality(c.GetHeader("Accept"), formatMediaTypes[format]); q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+	return best, nil
+}
+
+// rank orders offered by priority, keeping formats priority doesn't mention
+// at the end in their original order.
+func (n formatNegotiator) rank(offered []string) []string {
+	ranked := make([]string, 0, len(offered))
+	for _, format := range n.priority {
+		if slices.Contains(offered, format) {
+			ranked = append(ranked, format)
+		}
+	}
+	for _, format := range offered {
+		if !slices.Contains(ranked, format) {
+			ranked = append(ranked, format)
+		}
+	}
+	return ranked
+}
+
+// acceptQuality returns the q-value accept gives mediaType, taken from the
+// most specific matching range, or 0 if none matches. An empty header
+// accepts everything equally.
+func acceptQuality(accept, mediaType string) float64 {
+	if mediaType == "" {
+		return 0
+	}
+	if strings.TrimSpace(accept) == "" {
+		return 1
+	}
+	typ, _, _ := strings.Cut(mediaType, "/")
+	q, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		rangeType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		s := -1
+		switch rangeType {
+		case mediaType:
+			s = 2
+		case typ + "/*":
+			s = 1
+		case "*/*":
+			s = 0
+		}
+		if s <= specificity {
+			continue
+		}
+		specificity, q = s, 1
+		if value, ok := params["q"]; ok {
+			if v, err := strconv.ParseFloat(value, 64); err == nil {
+				q = v
+			}
+		}
+	}
+	return q
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAcceptQuality(t *testing.T) {
+	tests := []struct {
+		accept, mediaType string
+		want              float64
+	}{
+		{"", "application/json", 1},
+		{"application/json", "application/json", 1},
+		{"text/html", "application/json", 0},
+		{"text/*;q=0.5", "text/csv", 0.5},
+		{"*/*;q=0.1", "text/csv", 0.1},
+		{"text/*;q=0.5, text/csv;q=0.9, */*;q=0.1", "text/csv", 0.9},
+		{"text/csv;q=0.2, text/*", "text/csv", 0.2},
+		{"text/html, application/xhtml+xml, */*;q=0.8", "application/json", 0.8},
+		{"application/json;q=0", "application/json", 0},
+		{"application/json;q=oops", "application/json", 1},
+		{"not a media type, text/csv", "text/csv", 1},
+		{"application/json", "", 0},
+	}
+	for _, tt := range tests {
+		if got := acceptQuality(tt.accept, tt.mediaType); got != tt.want {
+			t.Errorf("acceptQuality(%q, %q) = %v, want %v", tt.accept, tt.mediaType, got, tt.want)
+		}
+	}
+}
+
+func TestFormatNegotiatorPick(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	offered := []string{formatHTML, formatJSON, formatCSV, formatSeries}
+	tests := []struct {
+		name     string
+		priority []string
+		target   string
+		accept   string
+		want     string
+		wantErr  bool
+	}{
+		{"query wins over accept", nil, "/?format=csv", "application/json", formatCSV, false},
+		{"query without a media type", nil, "/?format=series", "", formatSeries, false},
+		{"unoffered query", nil, "/?format=ics", "", "", true},
+		{"accept", nil, "/", "application/json", formatJSON, false},
+		{"highest q", nil, "/", "text/html;q=0.5, text/csv", formatCSV, false},
+		{"browser", nil, "/", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", formatHTML, false},
+		{"no accept takes the first offered", nil, "/", "", formatHTML, false},
+		{"no accept takes the priority", []string{formatJSON, formatHTML}, "/", "", formatJSON, false},
+		{"wildcard ties take the priority", []string{formatCSV}, "/", "*/*", formatCSV, false},
+		{"priority doesn't beat a better q", []string{formatJSON}, "/", "text/html", formatHTML, false},
+		{"nothing acceptable falls back", []string{formatJSON}, "/", "image/png", formatJSON, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.accept != "" {
+				c.Request.Header.Set("Accept", tt.accept)
+			}
+			got, err := formatNegotiator{priority: tt.priority}.pick(c, offered...)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("got %q, error %v; want %q, error %t", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"time"
+)
+
+var ErrQueueFull = errors.New("too many requests waiting on the weather service, try again later")
+
+var (
+	upstreamQueueDepth    = expvar.NewInt("upstream_queue_depth")
+	upstreamQueueRejected = expvar.NewInt("upstream_queue_rejected_total")
+	upstreamQueueWaits    = expvar.NewInt("upstream_queue_waits_total")
+	upstreamQueueWaitTime = expvar.NewFloat("upstream_queue_wait_seconds_total")
+)
+
+// upstreamQueue puts a bounded queue in front of the calls to open-meteo. At
+// most workers calls run at a time and at most size more may wait for a
+// turn; beyond that Do fails fast with ErrQueueFull instead of piling up
+// goroutines. Every upstream call goes through here, so it is also where
+// transient failures are retried.
+type upstreamQueue struct {
+	queue   chan struct{}
+	workers chan struct{}
+	retry   *retrier
+}
+
+func newUpstreamQueue(workers, size int, retry *retrier) *upstreamQueue {
+	return &upstreamQueue{
+		queue:   make(chan struct{}, workers+size),
+		workers: make(chan struct{}, workers),
+		retry:   retry,
+	}
+}
+
+// Do runs fn once a worker is free, or returns ErrQueueFull right away if the
+// queue is already at capacity and ctx's error if it is done before a worker
+// frees up. The worker is held while fn is retried.
+func (q *upstreamQueue) Do(ctx context.Context, fn func() error) error {
+	select {
+	case q.queue <- struct{}{}:
+	default:
+		upstreamQueueRejected.Add(1)
+		return ErrQueueFull
+	}
+	defer func() { <-q.queue }()
+
+	upstreamQueueDepth.Add(1)
+	start := time.Now()
+	select {
+	case q.workers <- struct{}{}:
+	case <-ctx.Done():
+		upstreamQueueDepth.Add(-1)
+		return ctx.Err()
+	}
+	upstreamQueueDepth.Add(-1)
+	upstreamQueueWaits.Add(1)
+	upstreamQueueWaitTime.Add(time.Since(start).Seconds())
+	defer func() { <-q.workers }()
+
+	return q.retry.Do(ctx, fn)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// holdWorkers fills q's workers with calls that block until the test ends.
+func holdWorkers(t *testing.T, q *upstreamQueue, n int) {
+	t.Helper()
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	for i := 0; i < n; i++ {
+		started := make(chan struct{})
+		go q.Do(context.Background(), func() error {
+			close(started)
+			<-release
+			return nil
+		})
+		<-started
+	}
+}
+
+func TestUpstreamQueueFull(t *testing.T) {
+	q := newUpstreamQueue(1, 1, &retrier{budget: newRetryBudget(0)})
+	holdWorkers(t, q, 1)
+
+	// The one waiting slot is taken by a call that gives up after a while.
+	ctx, cancel := context.WithCancel(context.Background())
+	waiting := make(chan error)
+	go func() { waiting <- q.Do(ctx, func() error { return nil }) }()
+	for upstreamQueueDepth.Value() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	rejected := upstreamQueueRejected.Value()
+	err := q.Do(context.Background(), func() error {
+		t.Error("ran a call on a full queue")
+		return nil
+	})
+	if !errors.Is(err, ErrQueueFull) || errorStatus(err) != http.StatusServiceUnavailable {
+		t.Errorf("got error %v, want a 503 ErrQueueFull", err)
+	}
+	if got := upstreamQueueRejected.Value() - rejected; got != 1 {
+		t.Errorf("upstream_queue_rejected_total went up by %d, want 1", got)
+	}
+	if got := upstreamQueueDepth.Value(); got != 1 {
+		t.Errorf("upstream_queue_depth = %d, want 1", got)
+	}
+
+	cancel()
+	if err := <-waiting; !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled wait: got error %v, want context.Canceled", err)
+	}
+	if got := upstreamQueueDepth.Value(); got != 0 {
+		t.Errorf("upstream_queue_depth = %d after the wait was cancelled, want 0", got)
+	}
+}
+
+func TestUpstreamQueueDeadline(t *testing.T) {
+	q := newUpstreamQueue(1, 4, &retrier{budget: newRetryBudget(0)})
+	holdWorkers(t, q, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := q.Do(ctx, func() error {
+		t.Error("ran a call after its deadline")
+		return nil
+	})
+	if !errors.Is(err, context.DeadlineExceeded) || errorStatus(err) != http.StatusServiceUnavailable {
+		t.Errorf("got error %v, want a 503 context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("waited %v for a worker past the deadline", elapsed)
+	}
+}
+
+func TestUpstreamQueueWaitMetrics(t *testing.T) {
+	q := newUpstreamQueue(2, 0, &retrier{budget: newRetryBudget(0)})
+	waits := upstreamQueueWaits.Value()
+	for i := 0; i < 3; i++ {
+		if err := q.Do(context.Background(), func() error { return nil }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := upstreamQueueWaits.Value() - waits; got != 3 {
+		t.Errorf("upstream_queue_waits_total went up by %d, want 3", got)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+// ipRateLimiter hands out a token bucket per client IP. Buckets that have
+// been idle for a while are dropped so the map doesn't grow forever.
+type ipRateLimiter struct {
+	rate  rate.Limit
+	burst int
+
+	mu      sync.Mutex
+	clients map[string]*client
+}
+
+type client struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newIPRateLimiter(r rate.Limit, burst int, idle time.Duration) *ipRateLimiter {
+	l := &ipRateLimiter{
+		rate:    r,
+		burst:   burst,
+		clients: make(map[string]*client),
+	}
+	go func() {
+		for range time.Tick(idle) {
+			l.cleanup(idle)
+		}
+	}()
+	return l
+}
+
+func (l *ipRateLimiter) get(ip string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c, ok := l.clients[ip]
+	if !ok {
+		c = &client{limiter: rate.NewLimiter(l.rate, l.burst)}
+		l.clients[ip] = c
+	}
+	c.lastSeen = time.Now()
+	return c.limiter
+}
+
+func (l *ipRateLimiter) cleanup(idle time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for ip, c := range l.clients {
+		if time.Since(c.lastSeen) > idle {
+			delete(l.clients, ip)
+		}
+	}
+}
+
+// Middleware answers 429 with a Retry-After header once a client has used
+// up its burst.
+func (l *ipRateLimiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reservation := l.get(c.ClientIP()).Reserve()
+		if delay := reservation.Delay(); delay > 0 {
+			reservation.Cancel()
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded, slow down"})
+			return
+		}
+		c.Next()
+	}
+}
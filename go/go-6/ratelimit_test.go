@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestIPRateLimiter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	l := newIPRateLimiter(1, 3, time.Hour)
+	r := gin.New()
+	if err := r.SetTrustedProxies([]string{"192.0.2.1"}); err != nil {
+		t.Fatal(err)
+	}
+	r.GET("/weather", l.Middleware(), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	get := func(remoteAddr, forwardedFor string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/weather", nil)
+		req.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := 1; i <= 3; i++ {
+		if rec := get("203.0.113.7:1234", ""); rec.Code != http.StatusOK {
+			t.Fatalf("request %d within the burst: got %d", i, rec.Code)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		rec := get("203.0.113.7:1234", "")
+		if rec.Code != http.StatusTooManyRequests {
+			t.Fatalf("request past the burst: got %d, want 429", rec.Code)
+		}
+		if got := rec.Header().Get("Retry-After"); got != "1" {
+			t.Errorf("Retry-After = %q, want 1", got)
+		}
+	}
+
+	if rec := get("203.0.113.8:1234", ""); rec.Code != http.StatusOK {
+		t.Errorf("another client: got %d, want 200", rec.Code)
+	}
+	// Behind the trusted proxy each forwarded client has its own bucket, but
+	// an untrusted peer can't escape its own by claiming to forward.
+	if rec := get("192.0.2.1:1234", "198.51.100.1"); rec.Code != http.StatusOK {
+		t.Errorf("client behind the proxy: got %d, want 200", rec.Code)
+	}
+	if rec := get("203.0.113.7:1234", "198.51.100.2"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("spoofed X-Forwarded-For: got %d, want 429", rec.Code)
+	}
+
+	time.Sleep(time.Millisecond)
+	l.cleanup(time.Nanosecond)
+	if n := len(l.clients); n != 0 {
+		t.Errorf("%d idle clients left after cleanup", n)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mre/goforecast/internal/weather"
+)
+
+// loadTemplates parses every file in dir as an HTML template. ParseGlob fails
+// with an unhelpful message on an empty match, so check first.
+func loadTemplates(dir string) (*template.Template, error) {
+	pattern := filepath.Join(dir, "*")
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no templates found in %q (set TEMPLATES_DIR)", dir)
+	}
+	return template.ParseGlob(pattern)
+}
+
+// htmlRenderer executes templates into a buffer and only sends the page once
+// it rendered completely. Rendering straight to the client, as c.HTML does,
+// leaves a half-written page behind when a template fails partway through.
+type htmlRenderer struct {
+	tmpl *template.Template
+}
+
+func (h htmlRenderer) render(c *gin.Context, status int, name string, data any) {
+	var buf bytes.Buffer
+	if err := h.tmpl.ExecuteTemplate(&buf, name, data); err != nil {
+		slog.Error("could not render template", "template", name, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not render page"})
+		return
+	}
+	c.Data(status, "text/html; charset=utf-8", buf.Bytes())
+}
+
+// weatherPage is the data behind weather.html: the forecast as a table of the
+// configured variables, plus the active unit and links that reload the page
+// in each unit. Rows replace the embedded display's Forecasts.
+type weatherPage struct {
+	weather.WeatherDisplay
+	Columns   []string
+	Rows      []weatherRow
+	Unit      weather.Unit
+	UnitLinks []unitLink
+}
+
+type weatherRow struct {
+	Date  string
+	Cells []string
+}
+
+type unitLink struct {
+	Unit   weather.Unit
+	URL    string
+	Active bool
+}
+
+// htmlColumn is a table column of the weather page and the hourly variable
+// it shows.
+type htmlColumn struct {
+	variable string
+	label    string
+	value    func(weather.Forecast) string
+}
+
+var htmlColumns = []htmlColumn{
+	{"temperature_2m", "Temperature", func(f weather.Forecast) string { return f.Temperature }},
+	{"surface_pressure", "Pressure", func(f weather.Forecast) string { return f.Pressure }},
+	{"precipitation", "Precipitation", func(f weather.Forecast) string { return f.Precipitation }},
+	{"snowfall", "Snowfall", func(f weather.Forecast) string { return f.Snowfall }},
+	{"wind_speed_10m", "Wind", func(f weather.Forecast) string { return f.WindSpeed }},
+}
+
+// smoothedColumn follows the temperature column when smoothing is on.
+var smoothedColumn = htmlColumn{"temperature_2m", "Smoothed", func(f weather.Forecast) string { return f.SmoothedTemperature }}
+
+// newWeatherPage builds the page for display rendered in unit, with a column
+// for each of variables. The unit links keep every other query parameter of
+// the current request URL.
+func newWeatherPage(display weather.WeatherDisplay, variables []string, unit weather.Unit, current *url.URL) weatherPage {
+	var columns []htmlColumn
+	for _, column := range htmlColumns {
+		if !slices.Contains(variables, column.variable) {
+			continue
+		}
+		columns = append(columns, column)
+		if column.variable == "temperature_2m" && display.Smoothed {
+			columns = append(columns, smoothedColumn)
+		}
+	}
+
+	page := weatherPage{WeatherDisplay: display, Unit: unit}
+	page.Forecasts = nil
+	for _, column := range columns {
+		page.Columns = append(page.Columns, column.label)
+	}
+	for _, f := range display.Forecasts {
+		row := weatherRow{Date: f.Date}
+		for _, column := range columns {
+			row.Cells = append(row.Cells, column.value(f))
+		}
+		page.Rows = append(page.Rows, row)
+	}
+
+	for _, u := range []weather.Unit{weather.Celsius, weather.Fahrenheit} {
+		query := current.Query()
+		query.Set("units", u.String())
+		link := url.URL{Path: current.Path, RawQuery: query.Encode()}
+		page.UnitLinks = append(page.UnitLinks, unitLink{Unit: u, URL: link.String(), Active: u == unit})
+	}
+	return page
+}
+
+// writeCSV sends a CSV document with a header line followed by rows.
+func writeCSV(c *gin.Context, header []string, rows [][]string) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(header)
+	w.WriteAll(rows)
+	if err := w.Error(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Data(http.StatusOK, "text/csv; charset=utf-8", buf.Bytes())
+}
+
+// forecastCSV sends one line per forecast hour. Hours without a temperature
+// leave the celsius column empty.
+func forecastCSV(c *gin.Context, forecasts []weather.Forecast) {
+	rows := make([][]string, len(forecasts))
+	for i, f := range forecasts {
+		celsius := ""
+		if !f.Missing {
+			celsius = strconv.FormatFloat(f.Celsius, 'f', -1, 64)
+		}
+		rows[i] = []string{f.Time.Format(time.RFC3339), f.Temperature, celsius, f.Pressure, f.Precipitation, f.Snowfall, f.WindSpeed}
+	}
+	writeCSV(c, []string{"time", "temperature", "celsius", "pressure", "precipitation", "snowfall", "wind_speed"}, rows)
+}
+
+// streamFlushEvery is how many forecasts are written between flushes when
+// streaming.
+const streamFlushEvery = 64
+
+// streamJSON writes display as JSON one forecast at a time instead of
+// building the whole document in memory, flushing periodically so the
+// client can start reading. The status is sent before the first forecast, so
+// an error halfway through can only be logged; the client is left with a
+// truncated document it will fail to parse.
+func streamJSON(c *gin.Context, display weather.WeatherDisplay) {
+	c.Header("Content-Type", "application/json; charset=utf-8")
+	c.Status(http.StatusOK)
+	w := c.Writer
+	enc := json.NewEncoder(w)
+
+	// Everything but the forecasts is encoded as c.JSON would, with
+	// Forecasts shadowed so it is left out; the closing brace is replaced by
+	// the streamed array. WeatherDisplay always has fields to encode, so the
+	// envelope is never just "{}".
+	envelope, err := json.Marshal(struct {
+		weather.WeatherDisplay
+		Forecasts []weather.Forecast `json:"forecasts,omitempty"`
+	}{WeatherDisplay: display})
+	if err == nil {
+		envelope[len(envelope)-1] = ','
+		_, err = w.Write(envelope)
+	}
+	if err == nil {
+		_, err = w.WriteString(`"forecasts":[`)
+	}
+	for i, forecast := range display.Forecasts {
+		if err != nil {
+			break
+		}
+		if i > 0 {
+			_, err = w.WriteString(",")
+		}
+		if err == nil {
+			err = enc.Encode(forecast)
+		}
+		if i%streamFlushEvery == streamFlushEvery-1 {
+			w.Flush()
+		}
+	}
+	if err == nil {
+		_, err = w.WriteString("]}\n")
+	}
+	if err != nil {
+		slog.Warn("error streaming forecast", "city", display.City, "error", err)
+		return
+	}
+	w.Flush()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mre/goforecast/internal/weather"
+)
+
+func TestHTMLRendererFailsCleanly(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tmpl := template.Must(template.New("stats.html").Parse(`<ul>{{range .}}<li>{{.Population}}</li>{{end}}</ul>`))
+	h := htmlRenderer{tmpl}
+
+	tests := []struct {
+		name        string
+		data        any
+		want        int
+		contentType string
+		body        string
+	}{
+		{"renders", []struct{ Population int }{{3_700_000}}, http.StatusOK, "text/html; charset=utf-8", "<ul><li>3700000</li></ul>"},
+		{"missing field", []string{"Berlin"}, http.StatusInternalServerError, "application/json; charset=utf-8", `{"error":"could not render page"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(rec)
+			h.render(c, http.StatusOK, "stats.html", tt.data)
+			if rec.Code != tt.want {
+				t.Errorf("got %d, want %d", rec.Code, tt.want)
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.contentType)
+			}
+			// The failed page must not leak its first, already rendered part.
+			if rec.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", rec.Body, tt.body)
+			}
+		})
+	}
+}
+
+func TestStreamJSON(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	many := make([]weather.Forecast, 3*streamFlushEvery+1)
+	for i := range many {
+		many[i] = weather.Forecast{Time: start.Add(time.Duration(i) * time.Hour), Temperature: "20.0 °C", Celsius: 20}
+	}
+	tests := []struct {
+		name    string
+		display weather.WeatherDisplay
+	}{
+		{"minimal", weather.WeatherDisplay{City: "Berlin"}},
+		{"everything", weather.WeatherDisplay{
+			City:                 "Berlin",
+			Place:                "Berlin, Germany",
+			Timezone:             "Europe/Berlin",
+			TimezoneAbbreviation: "CEST",
+			Forecasts:            many[:2],
+			Smoothed:             true,
+			Truncated:            true,
+			Implausible:          3,
+			Alerts:               []weather.Alert{{Time: start, Kind: "above", Temperature: "31.0 °C", Threshold: "30.0 °C"}},
+		}},
+		{"several flushes", weather.WeatherDisplay{City: "Berlin", Forecasts: many}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(rec)
+			streamJSON(c, tt.display)
+
+			var got, want map[string]any
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("streamed invalid JSON: %v\n%s", err, rec.Body)
+			}
+			encoded, _ := json.Marshal(tt.display)
+			json.Unmarshal(encoded, &want)
+			if want["forecasts"] == nil {
+				want["forecasts"] = []any{}
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("streamed\n%s\nwant the same as c.JSON:\n%s", rec.Body, encoded)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"container/list"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// responseCache keeps fully rendered responses for a short while so that
+// identical requests skip decoding, formatting and rendering. Entries are
+// keyed by method, path, query and Accept header; ?refresh skips the lookup
+// and replaces the entry. Once max entries are stored the oldest is evicted.
+// Responses marked Cache-Control: private are never stored.
+type responseCache struct {
+	ttl time.Duration
+	max int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type cachedResponse struct {
+	key    string
+	status int
+	// header holds the headers the handler set; those set per request by
+	// earlier middleware aren't part of the response being cached.
+	header  http.Header
+	body    []byte
+	stored  time.Time
+	expires time.Time
+}
+
+func newResponseCache(ttl time.Duration, max int) *responseCache {
+	return &responseCache{
+		ttl:     ttl,
+		max:     max,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// responseCacheKey identifies a request. The query is re-encoded so the order
+// of parameters doesn't matter.
+func responseCacheKey(r *http.Request) string {
+	query := r.URL.Query()
+	query.Del("refresh")
+	return r.Method + " " + r.URL.Path + "?" + query.Encode() + " " + r.Header.Get("Accept")
+}
+
+func (rc *responseCache) get(key string) (*cachedResponse, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	elem, ok := rc.entries[key]
+	if !ok {
+		return nil, false
+	}
+	resp := elem.Value.(*cachedResponse)
+	if time.Now().After(resp.expires) {
+		rc.order.Remove(elem)
+		delete(rc.entries, key)
+		return nil, false
+	}
+	return resp, true
+}
+
+func (rc *responseCache) set(resp *cachedResponse) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if elem, ok := rc.entries[resp.key]; ok {
+		rc.order.Remove(elem)
+	}
+	rc.entries[resp.key] = rc.order.PushBack(resp)
+	for rc.order.Len() > rc.max {
+		oldest := rc.order.Front()
+		rc.order.Remove(oldest)
+		delete(rc.entries, oldest.Value.(*cachedResponse).key)
+	}
+}
+
+// Middleware serves cached responses and stores successful ones. The status,
+// body and the headers set after this middleware are replayed, with Age
+// increased by the time spent in the cache; headers such as CORS are left to
+// the middleware that sets them per request. A zero TTL disables caching.
+func (rc *responseCache) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if rc.ttl <= 0 {
+			c.Next()
+			return
+		}
+
+		key := responseCacheKey(c.Request)
+		if _, refresh := c.GetQuery("refresh"); !refresh {
+			if resp, ok := rc.get(key); ok {
+				resp.replay(c)
+				c.Abort()
+				return
+			}
+		}
+
+		before := c.Writer.Header().Clone()
+		w := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+		c.Writer = w.ResponseWriter
+
+		if c.Writer.Status() == http.StatusOK && !strings.Contains(c.Writer.Header().Get("Cache-Control"), "private") {
+			now := time.Now()
+			rc.set(&cachedResponse{
+				key:     key,
+				status:  http.StatusOK,
+				header:  addedHeaders(before, c.Writer.Header()),
+				body:    w.body,
+				stored:  now,
+				expires: now.Add(rc.ttl),
+			})
+		}
+	}
+}
+
+// addedHeaders returns the headers in after that aren't in before with the
+// same values.
+func addedHeaders(before, after http.Header) http.Header {
+	added := make(http.Header, len(after))
+	for name, values := range after {
+		if !slices.Equal(before[name], values) {
+			added[name] = slices.Clone(values)
+		}
+	}
+	return added
+}
+
+// replay writes resp to c. The lookup headers describe this request, so a
+// replay reports a cache hit that didn't wait on open-meteo, and an Age that
+// includes the time spent in the response cache.
+func (resp *cachedResponse) replay(c *gin.Context) {
+	header := c.Writer.Header()
+	for name, values := range resp.header {
+		header[name] = slices.Clone(values)
+	}
+	if age, err := strconv.Atoi(resp.header.Get("Age")); err == nil {
+		header.Set("Age", strconv.Itoa(age+int(time.Since(resp.stored).Seconds())))
+	}
+	if resp.header.Get("X-Cache") != "" {
+		header.Set("X-Cache", cacheHit)
+	}
+	if resp.header.Get("X-Upstream-Latency") != "" {
+		header.Set("X-Upstream-Latency", time.Duration(0).String())
+	}
+	c.Data(resp.status, resp.header.Get("Content-Type"), resp.body)
+}
+
+// recordingWriter passes the response through while keeping a copy of the
+// body.
+type recordingWriter struct {
+	gin.ResponseWriter
+	body []byte
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body = append(w.body, b...)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body = append(w.body, s...)
+	return w.ResponseWriter.WriteString(s)
+}
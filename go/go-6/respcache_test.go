@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestResponseCacheReplaysHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	rc := newResponseCache(time.Minute, 10)
+	calls, requests := 0, 0
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		requests++
+		c.Header("X-Request-ID", strconv.Itoa(requests))
+	})
+	r.GET("/weather", rc.Middleware(), func(c *gin.Context) {
+		calls++
+		c.Header("X-Cache", cacheMiss)
+		c.Header("X-Upstream-Latency", "120ms")
+		c.Header("Age", "10")
+		c.Header("Cache-Control", "max-age=50")
+		c.Header("Vary", "Accept")
+		c.String(http.StatusOK, "sunny")
+	})
+
+	get := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/weather?city=Berlin", nil))
+		return rec
+	}
+	if first := get(); first.Header().Get("X-Cache") != cacheMiss {
+		t.Errorf("first response: X-Cache = %q, want %q", first.Header().Get("X-Cache"), cacheMiss)
+	}
+	for _, elem := range rc.entries {
+		elem.Value.(*cachedResponse).stored = time.Now().Add(-5 * time.Second)
+	}
+	rec := get()
+
+	if calls != 1 {
+		t.Fatalf("handler ran %d times, want 1", calls)
+	}
+	want := map[string]string{
+		"X-Cache":            cacheHit,
+		"X-Upstream-Latency": "0s",
+		"Age":                "15",
+		"Cache-Control":      "max-age=50",
+		"Vary":               "Accept",
+		"Content-Type":       "text/plain; charset=utf-8",
+		"X-Request-ID":       "2",
+	}
+	for name, value := range want {
+		if got := rec.Header().Get(name); got != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+	if rec.Body.String() != "sunny" {
+		t.Errorf("body = %q", rec.Body)
+	}
+}
+
+func TestResponseCacheSkipsPrivate(t *testing.T) {
+	rc := newResponseCache(time.Minute, 10)
+	calls := 0
+	r := gin.New()
+	r.GET("/weather", rc.Middleware(), func(c *gin.Context) {
+		calls++
+		c.Header("Cache-Control", "private")
+		c.String(http.StatusOK, "local")
+	})
+	for i := 0; i < 2; i++ {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/weather", nil))
+	}
+	if calls != 2 {
+		t.Errorf("handler ran %d times, want 2", calls)
+	}
+}
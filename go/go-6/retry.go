@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mre/goforecast/internal/weather"
+)
+
+var (
+	upstreamRetries       = expvar.NewInt("upstream_retries_total")
+	upstreamRetriesDenied = expvar.NewInt("upstream_retries_denied_total")
+)
+
+// retryBudgetMax caps how many retry tokens can pile up while things are
+// healthy, which bounds the burst of retries when an outage starts.
+const retryBudgetMax = 10
+
+// retryBackoff is the wait before the first retry; it doubles after that.
+const retryBackoff = 100 * time.Millisecond
+
+// maxRetryAfter is the longest Retry-After we wait out while holding a
+// queue worker. Asked to wait longer, we give up and leave it to the caller,
+// who can serve a stale forecast meanwhile.
+const maxRetryAfter = 5 * time.Second
+
+// retryBudget is a token bucket shared by every upstream call. Each call
+// deposits ratio tokens and each retry spends a whole one, so in the long run
+// at most that fraction of calls is retried. During a broad outage the bucket
+// drains and calls fail fast instead of multiplying the load on open-meteo.
+type retryBudget struct {
+	mu     sync.Mutex
+	tokens float64
+	ratio  float64
+}
+
+func newRetryBudget(ratio float64) *retryBudget {
+	return &retryBudget{tokens: retryBudgetMax, ratio: ratio}
+}
+
+func (b *retryBudget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = math.Min(retryBudgetMax, b.tokens+b.ratio)
+}
+
+// withdraw takes a token for a retry, reporting false if none is left.
+func (b *retryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// retrier retries transient upstream failures up to retries times, as long
+// as the shared budget allows it.
+type retrier struct {
+	retries int
+	budget  *retryBudget
+}
+
+// Do calls fn until it succeeds, fails for good or runs out of retries. It
+// stops waiting between attempts, returning ctx's error, once ctx is done.
+func (r *retrier) Do(ctx context.Context, fn func() error) error {
+	r.budget.deposit()
+	backoff := retryBackoff
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= r.retries || !isTransient(err) {
+			return err
+		}
+		wait := backoff
+		var statusErr *weather.StatusError
+		if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+			if statusErr.RetryAfter > maxRetryAfter {
+				return err
+			}
+			wait = statusErr.RetryAfter
+		}
+		if !r.budget.withdraw() {
+			upstreamRetriesDenied.Add(1)
+			return err
+		}
+		upstreamRetries.Add(1)
+		slog.Warn("upstream call failed, retrying", "attempt", attempt+1, "backoff", wait, "error", err)
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// isTransient reports whether err is worth retrying. Network errors, including
+// failed DNS lookups, responses cut off mid-body, server errors and rate
+// limiting are; other answers from open-meteo, even unhelpful ones, are not.
+func isTransient(err error) bool {
+	var netErr net.Error
+	var statusErr *weather.StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Code >= 500 || statusErr.Code == http.StatusTooManyRequests
+	}
+	return errors.As(err, &netErr) || errors.Is(err, weather.ErrUpstreamUnreachable) ||
+		errors.Is(err, weather.ErrTruncatedResponse)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mre/goforecast/internal/weather"
+)
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"dns failure", fmt.Errorf("wrapped: %w", &net.DNSError{Err: "no such host", Name: "api.open-meteo.com"}), true},
+		{"unreachable", weather.ErrUpstreamUnreachable, true},
+		{"truncated", fmt.Errorf("%w: body ends after 10 bytes", weather.ErrTruncatedResponse), true},
+		{"service unavailable", &weather.StatusError{Code: http.StatusServiceUnavailable}, true},
+		{"internal server error", fmt.Errorf("error making request: %w", &weather.StatusError{Code: 500}), true},
+		{"rate limited", &weather.StatusError{Code: http.StatusTooManyRequests}, true},
+		{"bad request", &weather.StatusError{Code: http.StatusBadRequest}, false},
+		{"not found", &weather.StatusError{Code: http.StatusNotFound}, false},
+		{"no results", weather.ErrNoResults, false},
+		{"other", io.ErrClosedPipe, false},
+	}
+	for _, tt := range tests {
+		if got := isTransient(tt.err); got != tt.want {
+			t.Errorf("%s: isTransient = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRetrierRetryAfter(t *testing.T) {
+	tests := []struct {
+		name       string
+		retryAfter time.Duration
+		wantCalls  int
+		minWait    time.Duration
+	}{
+		{"waits as asked", 150 * time.Millisecond, 2, 150 * time.Millisecond},
+		{"gives up when asked to wait too long", time.Minute, 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &retrier{retries: 2, budget: newRetryBudget(1)}
+			calls := 0
+			start := time.Now()
+			err := r.Do(context.Background(), func() error {
+				calls++
+				if calls == 1 {
+					return &weather.StatusError{Code: http.StatusTooManyRequests, RetryAfter: tt.retryAfter}
+				}
+				return nil
+			})
+			elapsed := time.Since(start)
+			if calls != tt.wantCalls {
+				t.Errorf("got %d calls, want %d", calls, tt.wantCalls)
+			}
+			if (tt.wantCalls > 1) != (err == nil) {
+				t.Errorf("unexpected error %v", err)
+			}
+			if elapsed < tt.minWait {
+				t.Errorf("retried after %v, want at least %v", elapsed, tt.minWait)
+			}
+			if tt.wantCalls == 1 && elapsed > time.Second {
+				t.Errorf("took %v to give up", elapsed)
+			}
+		})
+	}
+}
+
+func TestRetrierBudget(t *testing.T) {
+	r := &retrier{retries: 3, budget: &retryBudget{}}
+	calls := 0
+	err := r.Do(context.Background(), func() error {
+		calls++
+		return &weather.StatusError{Code: http.StatusServiceUnavailable}
+	})
+	if calls != 1 || !errors.As(err, new(*weather.StatusError)) {
+		t.Errorf("got %d calls and error %v, want 1 call failing without retries", calls, err)
+	}
+}
+
+func TestRetrierCancelledDuringBackoff(t *testing.T) {
+	r := &retrier{retries: 3, budget: newRetryBudget(1)}
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	start := time.Now()
+	err := r.Do(ctx, func() error {
+		calls++
+		time.AfterFunc(10*time.Millisecond, cancel)
+		return &weather.StatusError{Code: http.StatusTooManyRequests, RetryAfter: maxRetryAfter}
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want context.Canceled", err)
+	}
+	if calls != 1 {
+		t.Errorf("got %d calls, want no retry after the cancellation", calls)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("kept waiting %v after the cancellation", elapsed)
+	}
+}
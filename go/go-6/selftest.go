@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/mre/goforecast/internal/weather"
+)
+
+// selftest runs the forecast pipeline for city end to end, geocoding,
+// fetching and extracting, without the database or the caches, and prints
+// how long each step took. It is meant as a smoke test for CI and deploys:
+// point GEOCODING_URL and FORECAST_URL at a mock to run it offline.
+func selftest(w io.Writer, city string, opts weather.Options) error {
+	start := time.Now()
+	step := func(name string, since time.Time) {
+		fmt.Fprintf(w, "%-10s %v\n", name, time.Since(since).Round(time.Millisecond))
+	}
+
+	t := time.Now()
+	place, err := weather.FetchLatLong(context.Background(), city)
+	if err != nil {
+		return fmt.Errorf("geocoding %q: %w", city, err)
+	}
+	step("geocode", t)
+
+	t = time.Now()
+	forecast, err := weather.GetWeather(context.Background(), place.LatLong, weather.ForecastParams{})
+	if err != nil {
+		return fmt.Errorf("fetching forecast: %w", err)
+	}
+	step("forecast", t)
+
+	t = time.Now()
+	display, err := weather.ExtractWeatherData(city, forecast, opts)
+	if err != nil {
+		return fmt.Errorf("extracting forecast: %w", err)
+	}
+	step("extract", t)
+
+	fmt.Fprintf(w, "ok: %s, %d hourly forecasts in %v\n", place.FullName(), len(display.Forecasts), time.Since(start).Round(time.Millisecond))
+	return nil
+}
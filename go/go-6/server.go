@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// loadTLSConfig loads the certificate and key for serving HTTPS. It returns
+// nil if neither file is configured.
+func loadTLSConfig(certFile, keyFile string) (*tls.Config, error) {
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading TLS certificate: %w", err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+}
+
+// runServer serves handler on addr until SIGINT or SIGTERM, then shuts down
+// gracefully: it stops accepting connections and waits up to timeout for
+// in-flight requests before closing whatever is still open. With a TLS
+// config it serves HTTPS, which also enables HTTP/2.
+func runServer(handler http.Handler, addr string, tlsConfig *tls.Config, timeout time.Duration) error {
+	srv := &http.Server{Addr: addr, Handler: handler, TLSConfig: tlsConfig}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errs := make(chan error, 1)
+	go func() {
+		slog.Info("listening", "addr", addr, "tls", tlsConfig != nil)
+		if tlsConfig != nil {
+			// The certificate comes from TLSConfig.
+			errs <- srv.ListenAndServeTLS("", "")
+			return
+		}
+		errs <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("shutting down", "timeout", timeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("shutdown timed out, closing remaining connections", "error", err)
+		return srv.Close()
+	}
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	slog.Info("shutdown complete")
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+
+	"github.com/mre/goforecast/internal/weather"
+)
+
+// warmCache geocodes each city and fetches its forecast so the first real
+// request for it is served from the caches. Up to concurrency cities are
+// warmed at once; their upstream calls still go through the shared queue, so
+// warming can't crowd out user traffic. Failures are logged and skipped.
+func warmCache(geo *geocoder, forecasts *weatherCache, cities []string, concurrency int) {
+	slog.Info("warming cache", "cities", len(cities), "concurrency", concurrency)
+
+	jobs := make(chan string)
+	var done, warmed atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for city := range jobs {
+				place, err := geo.getLatLong(context.Background(), city)
+				if err == nil {
+					_, err = forecasts.Get(context.Background(), place.LatLong, weather.ForecastParams{})
+				}
+				n := done.Add(1)
+				if err != nil {
+					slog.Warn("could not warm city", "city", city, "error", err)
+					continue
+				}
+				warmed.Add(1)
+				slog.Info("warmed city", "city", city, "done", n, "total", len(cities))
+			}
+		}()
+	}
+
+	for _, city := range cities {
+		jobs <- city
+	}
+	close(jobs)
+	wg.Wait()
+
+	slog.Info("cache warming finished", "warmed", warmed.Load(), "failed", int64(len(cities))-warmed.Load())
+}
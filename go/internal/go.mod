@@ -0,0 +1,5 @@
+module github.com/mre/goforecast/internal
+
+go 1.19
+
+require golang.org/x/text v0.13.0
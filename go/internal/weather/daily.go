@@ -0,0 +1,74 @@
+package weather
+
+import "fmt"
+
+// FullDisplay combines open-meteo's daily summaries with the hourly forecasts
+// of each day.
+type FullDisplay struct {
+	City  string `json:"city"`
+	Place string `json:"place"`
+	Days  []Day  `json:"days"`
+}
+
+// Day is one entry of the daily block together with the hourly forecasts
+// that fall on it.
+type Day struct {
+	// Date is the calendar date in the location's timezone, e.g.
+	// "2024-01-31".
+	Date string `json:"date"`
+	// Min and Max are Options.Missing, and MinCelsius and MaxCelsius nil,
+	// for days open-meteo reported null for.
+	Min           string     `json:"min"`
+	Max           string     `json:"max"`
+	MinCelsius    *float64   `json:"min_celsius"`
+	MaxCelsius    *float64   `json:"max_celsius"`
+	Precipitation string     `json:"precipitation"`
+	Hourly        []Forecast `json:"hourly"`
+}
+
+// GroupByDay turns the daily block of weatherResponse into Days and nests
+// each of forecasts under the day it falls on. forecasts must still be in
+// the location's timezone, as ExtractWeatherData returns them; hours on days
+// the daily block doesn't cover are left out.
+func GroupByDay(weatherResponse *WeatherResponse, forecasts []Forecast, opts Options) ([]Day, error) {
+	daily := weatherResponse.Daily
+	if len(daily.Time) == 0 {
+		return nil, ErrNoForecastData
+	}
+	if len(daily.Temperature2mMax) != len(daily.Time) || len(daily.Temperature2mMin) != len(daily.Time) {
+		return nil, fmt.Errorf("malformed weather response: %d days but %d maximum and %d minimum temperatures",
+			len(daily.Time), len(daily.Temperature2mMax), len(daily.Temperature2mMin))
+	}
+
+	toCelsius := func(v float64) float64 { return v }
+	if weatherResponse.DailyUnits.Temperature2mMax == "°F" {
+		toCelsius = Fahrenheit.ToCelsius
+	}
+	precipitationUnit := unitOr(weatherResponse.DailyUnits.PrecipitationSum, "mm")
+
+	days := make([]Day, len(daily.Time))
+	index := make(map[string]int, len(daily.Time))
+	for i, date := range daily.Time {
+		days[i] = Day{Date: date, Min: opts.Missing, Max: opts.Missing, Hourly: []Forecast{}}
+		if reading := daily.Temperature2mMin[i]; reading != nil {
+			celsius := toCelsius(*reading)
+			days[i].Min, days[i].MinCelsius = opts.Format.Format(celsius), &celsius
+		}
+		if reading := daily.Temperature2mMax[i]; reading != nil {
+			celsius := toCelsius(*reading)
+			days[i].Max, days[i].MaxCelsius = opts.Format.Format(celsius), &celsius
+		}
+		days[i].Precipitation = opts.Missing
+		if i < len(daily.PrecipitationSum) && daily.PrecipitationSum[i] != nil {
+			days[i].Precipitation = formatAmount(*daily.PrecipitationSum[i], 1, precipitationUnit)
+		}
+		index[date] = i
+	}
+
+	for _, f := range forecasts {
+		if i, ok := index[f.Time.Format("2006-01-02")]; ok {
+			days[i].Hourly = append(days[i].Hourly, f)
+		}
+	}
+	return days, nil
+}
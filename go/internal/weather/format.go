@@ -0,0 +1,180 @@
+package weather
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// RoundingMode selects how a temperature is rounded to the configured
+// number of decimal places.
+type RoundingMode int
+
+const (
+	// RoundHalfEven rounds ties to the nearest even digit (21.25 -> 21.2).
+	RoundHalfEven RoundingMode = iota
+	// RoundHalfUp rounds ties away from zero (21.25 -> 21.3).
+	RoundHalfUp
+)
+
+// ParseRoundingMode parses "half-even" or "half-up".
+func ParseRoundingMode(s string) (RoundingMode, error) {
+	switch s {
+	case "half-even":
+		return RoundHalfEven, nil
+	case "half-up":
+		return RoundHalfUp, nil
+	default:
+		return 0, fmt.Errorf("unknown rounding mode %q (want half-even or half-up)", s)
+	}
+}
+
+// Unit is the temperature scale values are displayed in. open-meteo always
+// reports Celsius; conversion happens when formatting.
+type Unit int
+
+const (
+	Celsius Unit = iota
+	Fahrenheit
+)
+
+// ParseUnit parses "celsius" or "fahrenheit".
+func ParseUnit(s string) (Unit, error) {
+	switch s {
+	case "celsius":
+		return Celsius, nil
+	case "fahrenheit":
+		return Fahrenheit, nil
+	default:
+		return 0, fmt.Errorf("unknown unit %q (want celsius or fahrenheit)", s)
+	}
+}
+
+// ToCelsius converts a temperature given in u to Celsius.
+func (u Unit) ToCelsius(v float64) float64 {
+	if u == Fahrenheit {
+		return (v - 32) * 5 / 9
+	}
+	return v
+}
+
+func (u Unit) String() string {
+	if u == Fahrenheit {
+		return "fahrenheit"
+	}
+	return "celsius"
+}
+
+// SuffixStyle selects how the unit is written after the number.
+type SuffixStyle int
+
+const (
+	// SuffixSymbol writes "°C".
+	SuffixSymbol SuffixStyle = iota
+	// SuffixAbbreviation writes "C".
+	SuffixAbbreviation
+	// SuffixWord writes "celsius".
+	SuffixWord
+)
+
+// ParseSuffixStyle parses "symbol", "abbreviation" or "word".
+func ParseSuffixStyle(s string) (SuffixStyle, error) {
+	switch s {
+	case "symbol":
+		return SuffixSymbol, nil
+	case "abbreviation":
+		return SuffixAbbreviation, nil
+	case "word":
+		return SuffixWord, nil
+	default:
+		return 0, fmt.Errorf("unknown unit suffix %q (want symbol, abbreviation or word)", s)
+	}
+}
+
+// TemperatureFormat controls how temperatures are rendered for display.
+type TemperatureFormat struct {
+	Decimals int
+	Rounding RoundingMode
+	// Locale picks the decimal separator and whether the unit is set off by
+	// a space ("21,5 °C" in German). The zero value renders "21.5°C".
+	Locale language.Tag
+	Unit   Unit
+	Suffix SuffixStyle
+}
+
+// DefaultFormat renders temperatures with one decimal, e.g. "21.5°C".
+var DefaultFormat = TemperatureFormat{Decimals: 1, Rounding: RoundHalfEven}
+
+// Format converts a Celsius reading to the configured unit and renders it.
+func (f TemperatureFormat) Format(celsius float64) string {
+	v := celsius
+	if f.Unit == Fahrenheit {
+		v = celsius*9/5 + 32
+	}
+	v = round(v, f.Decimals, f.Rounding)
+
+	var number string
+	if f.Locale == language.Und {
+		number = strconv.FormatFloat(v, 'f', f.Decimals, 64)
+	} else {
+		number = message.NewPrinter(f.Locale).Sprintf("%.*f", f.Decimals, v)
+	}
+	return number + f.suffix()
+}
+
+func (f TemperatureFormat) suffix() string {
+	if f.Suffix == SuffixWord {
+		return " " + f.Unit.String()
+	}
+
+	letter := "C"
+	if f.Unit == Fahrenheit {
+		letter = "F"
+	}
+	if f.Suffix == SuffixSymbol {
+		letter = "°" + letter
+	}
+	if base, _ := f.Locale.Base(); f.Locale != language.Und && spacedUnits[base] {
+		return " " + letter
+	}
+	return letter
+}
+
+// spacedUnits lists the languages whose typography puts a space between a
+// number and its unit.
+var spacedUnits = map[language.Base]bool{
+	language.MustParseBase("cs"): true,
+	language.MustParseBase("da"): true,
+	language.MustParseBase("de"): true,
+	language.MustParseBase("es"): true,
+	language.MustParseBase("fi"): true,
+	language.MustParseBase("fr"): true,
+	language.MustParseBase("it"): true,
+	language.MustParseBase("nb"): true,
+	language.MustParseBase("nl"): true,
+	language.MustParseBase("pl"): true,
+	language.MustParseBase("pt"): true,
+	language.MustParseBase("ru"): true,
+	language.MustParseBase("sv"): true,
+}
+
+// round rounds v to the given number of decimal places. We do this ourselves
+// rather than leaving it to strconv so the tie-breaking rule is explicit.
+func round(v float64, decimals int, mode RoundingMode) float64 {
+	scale := math.Pow10(decimals)
+	var r float64
+	switch mode {
+	case RoundHalfUp:
+		r = math.Round(v*scale) / scale
+	default:
+		r = math.RoundToEven(v*scale) / scale
+	}
+	// Avoid rendering "-0.0°C" for small negative values.
+	if r == 0 {
+		return 0
+	}
+	return r
+}
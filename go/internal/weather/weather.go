@@ -0,0 +1,744 @@
+// Package weather contains the geocoding and forecast logic shared by the Go
+// servers: looking up a city's coordinates, fetching its forecast from
+// open-meteo and turning the response into something we can display.
+package weather
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoForecastData is returned when open-meteo answers successfully but has
+// no hourly data for the location.
+var ErrNoForecastData = errors.New("no forecast data available for this location")
+
+// ErrNoResults is returned when the geocoding API knows no place by the
+// given name or postal code. It is only returned for successful responses,
+// so an outage is never mistaken for a place that doesn't exist.
+var ErrNoResults = errors.New("no results found")
+
+// ErrTruncatedResponse is returned when a response body ends before the
+// JSON does, usually because the connection dropped.
+var ErrTruncatedResponse = errors.New("truncated response from weather service")
+
+// ErrUpstreamUnreachable is matched by errors for requests that never reached
+// open-meteo because its hostname didn't resolve, e.g. during a DNS outage.
+var ErrUpstreamUnreachable = errors.New("weather service unreachable")
+
+// unreachableError wraps the *net.DNSError behind an ErrUpstreamUnreachable,
+// so callers can still recognise it as a net.Error.
+type unreachableError struct {
+	err *net.DNSError
+}
+
+func (e *unreachableError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrUpstreamUnreachable, e.err)
+}
+
+func (e *unreachableError) Unwrap() error { return e.err }
+
+func (e *unreachableError) Is(target error) bool { return target == ErrUpstreamUnreachable }
+
+// requestFailed describes an error from Client for a request to api.
+func requestFailed(api string, err error) error {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return &unreachableError{dnsErr}
+	}
+	return fmt.Errorf("error making request to %s: %w", api, err)
+}
+
+// StatusError is returned when open-meteo answers with a status outside
+// 2xx, e.g. during maintenance or when it is rate limiting us.
+type StatusError struct {
+	Code int
+	// Reason is the explanation open-meteo gave in the body, if any.
+	Reason string
+	// RetryAfter is how long the response asked us to wait before trying
+	// again, or zero if it didn't say.
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	msg := fmt.Sprintf("weather service answered %d %s", e.Code, http.StatusText(e.Code))
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+	return msg
+}
+
+// maxErrorBody is how much of an error response newStatusError reads
+// looking for a reason.
+const maxErrorBody = 4 << 10
+
+// newStatusError describes resp, a non-2xx response, and closes its body.
+// open-meteo explains errors as {"error": true, "reason": "..."}.
+func newStatusError(resp *http.Response) *StatusError {
+	defer resp.Body.Close()
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, maxErrorBody)).Decode(&body)
+	return &StatusError{
+		Code:       resp.StatusCode,
+		Reason:     body.Reason,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+}
+
+// parseRetryAfter reads a Retry-After header, given either in seconds or as
+// an HTTP date. Anything else, or a time already past, yields zero.
+func parseRetryAfter(value string) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds > 0 {
+			return time.Duration(seconds) * time.Second
+		}
+		return 0
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(t); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// ErrAmbiguousCity is matched by an *AmbiguousCityError, returned when a
+// city name fits several places about equally well.
+var ErrAmbiguousCity = errors.New("ambiguous city name")
+
+// AmbiguousCityError lists the places a city name could mean, best match
+// first.
+type AmbiguousCityError struct {
+	City       string
+	Candidates []Place
+}
+
+func (e *AmbiguousCityError) Error() string {
+	return fmt.Sprintf("%q matches %d places, please be more specific", e.City, len(e.Candidates))
+}
+
+func (e *AmbiguousCityError) Unwrap() error { return ErrAmbiguousCity }
+
+// GeocodingEndpoint and ForecastEndpoint are the open-meteo APIs we call.
+// They are variables so tests and smoke checks can point them at a mock.
+var (
+	GeocodingEndpoint = "https://geocoding-api.open-meteo.com/v1/search"
+	ForecastEndpoint  = "https://api.open-meteo.com/v1/forecast"
+)
+
+// DefaultTimeout bounds each request Client makes, body included.
+const DefaultTimeout = 10 * time.Second
+
+// Client is used for every request to open-meteo. By default it honours
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY like http.DefaultClient does.
+var Client = NewClient(nil, DefaultTimeout)
+
+// NewClient returns an HTTP client for talking to open-meteo that gives up
+// on a request after timeout. Requests go through proxyURL if it is set, and
+// otherwise through the proxy configured in the environment.
+func NewClient(proxyURL *url.URL, timeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	if proxyURL != nil {
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	return &http.Client{Transport: transport, Timeout: timeout}
+}
+
+// get requests endpoint with Client, asking for a gzipped response. Setting
+// Accept-Encoding ourselves turns off the transport's own decompression, so
+// a gzipped body is unpacked here. Responses outside 2xx are returned as a
+// *StatusError.
+func get(ctx context.Context, endpoint string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		return checkStatus(resp)
+	}
+
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		resp.Body.Close()
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("%w: gzip header cut off", ErrTruncatedResponse)
+		}
+		return nil, fmt.Errorf("error decompressing response: %w", err)
+	}
+	resp.Body = gzipBody{zr, resp.Body}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return checkStatus(resp)
+}
+
+// checkStatus returns resp if it succeeded, and a *StatusError otherwise.
+func checkStatus(resp *http.Response) (*http.Response, error) {
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, newStatusError(resp)
+	}
+	return resp, nil
+}
+
+// gzipBody decompresses a response body and closes it along with itself.
+type gzipBody struct {
+	*gzip.Reader
+	body io.ReadCloser
+}
+
+func (b gzipBody) Close() error {
+	b.Reader.Close()
+	return b.body.Close()
+}
+
+type GeoResponse struct {
+	Results []Place `json:"results"`
+}
+
+type LatLong struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// Place is a geocoding match: its coordinates and the name, first-level
+// administrative area (state, region) and country it resolved to.
+type Place struct {
+	LatLong
+	Name    string `json:"name"`
+	Admin1  string `json:"admin1"`
+	Country string `json:"country"`
+	// Population is zero when the geocoding API doesn't know it.
+	Population int `json:"population,omitempty"`
+}
+
+// FullName returns the place's name with its region and country, e.g.
+// "Springfield, Illinois, United States", skipping parts that are unknown or
+// repeat the name.
+func (p Place) FullName() string {
+	parts := make([]string, 0, 3)
+	for _, part := range []string{p.Name, p.Admin1, p.Country} {
+		if part != "" && (len(parts) == 0 || part != parts[len(parts)-1]) {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+type WeatherResponse struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Timezone  string  `json:"timezone"`
+	// TimezoneAbbreviation is the zone's short name at the start of the
+	// forecast, e.g. "CEST".
+	TimezoneAbbreviation string `json:"timezone_abbreviation"`
+	// GenerationTimeMs is how long open-meteo took to compute the response.
+	GenerationTimeMs float64 `json:"generationtime_ms"`
+	// UTCOffsetSeconds is the location's offset at the start of the forecast,
+	// used when Timezone isn't a zone we know.
+	UTCOffsetSeconds int `json:"utc_offset_seconds"`
+	Hourly           struct {
+		// The values are pointers because open-meteo reports null for hours
+		// it has no value for.
+		Time            []string   `json:"time"`
+		Temperature2m   []*float64 `json:"temperature_2m"`
+		SurfacePressure []*float64 `json:"surface_pressure"`
+		Precipitation   []*float64 `json:"precipitation"`
+		Snowfall        []*float64 `json:"snowfall"`
+		WindSpeed10m    []*float64 `json:"wind_speed_10m"`
+	} `json:"hourly"`
+	// HourlyUnits describes the unit of each hourly variable, e.g. "°C" or
+	// "hPa".
+	HourlyUnits struct {
+		Temperature2m   string `json:"temperature_2m"`
+		SurfacePressure string `json:"surface_pressure"`
+		Precipitation   string `json:"precipitation"`
+		Snowfall        string `json:"snowfall"`
+		WindSpeed10m    string `json:"wind_speed_10m"`
+	} `json:"hourly_units"`
+	// Daily is only present when the request set ForecastParams.Daily. Its
+	// times are dates in the location's timezone.
+	Daily struct {
+		Time             []string   `json:"time"`
+		Temperature2mMax []*float64 `json:"temperature_2m_max"`
+		Temperature2mMin []*float64 `json:"temperature_2m_min"`
+		PrecipitationSum []*float64 `json:"precipitation_sum"`
+	} `json:"daily"`
+	DailyUnits struct {
+		Temperature2mMax string `json:"temperature_2m_max"`
+		PrecipitationSum string `json:"precipitation_sum"`
+	} `json:"daily_units"`
+	// Raw is the response body as open-meteo sent it, kept for caching.
+	Raw []byte `json:"-"`
+}
+
+// DecodeWeather parses an open-meteo forecast response, keeping raw in the
+// result.
+func DecodeWeather(raw []byte) (*WeatherResponse, error) {
+	var weatherResponse WeatherResponse
+	if err := json.Unmarshal(raw, &weatherResponse); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) && syntaxErr.Offset >= int64(len(raw)) {
+			return nil, fmt.Errorf("%w: JSON ends after %d bytes", ErrTruncatedResponse, len(raw))
+		}
+		return nil, fmt.Errorf("error decoding weather response: %w", err)
+	}
+	weatherResponse.Raw = raw
+	return &weatherResponse, nil
+}
+
+// WeatherDisplay and Forecast are also served as JSON, so their tags are part
+// of the API.
+type WeatherDisplay struct {
+	City string `json:"city"`
+	// Place is the full name of the place City resolved to, if known.
+	Place string `json:"place"`
+	// Timezone and TimezoneAbbreviation name the zone the forecast times
+	// are in, e.g. "Europe/Berlin" and "CEST".
+	Timezone             string     `json:"timezone"`
+	TimezoneAbbreviation string     `json:"timezone_abbreviation"`
+	Forecasts            []Forecast `json:"forecasts"`
+	Smoothed             bool       `json:"smoothed"`
+	// Truncated is set when the response had more hourly entries than
+	// Options.MaxEntries allows and the rest were dropped.
+	Truncated bool `json:"truncated"`
+	// Implausible counts the hours dropped because their temperature was
+	// outside Options.MinCelsius and Options.MaxCelsius.
+	Implausible int `json:"implausible,omitempty"`
+	// Alerts lists the forecast hours that crossed a requested threshold
+	// and any severe-weather warnings for the location.
+	Alerts []Alert `json:"alerts,omitempty"`
+}
+
+// maxTimezoneLabel is the longest timezone name TimezoneLabel shows before
+// falling back to the abbreviation.
+const maxTimezoneLabel = 20
+
+// TimezoneLabel names the forecast's timezone for display: the full name, or
+// the abbreviation if the name is long ("America/Argentina/Buenos_Aires") or
+// unknown.
+func (d WeatherDisplay) TimezoneLabel() string {
+	if d.TimezoneAbbreviation != "" && (d.Timezone == "" || len(d.Timezone) > maxTimezoneLabel) {
+		return d.TimezoneAbbreviation
+	}
+	return d.Timezone
+}
+
+// Alert is either a forecast hour whose temperature is below or above a
+// threshold, or a severe-weather warning from an alerts service.
+type Alert struct {
+	// Time is the forecast hour, or when a warning takes effect.
+	Time time.Time `json:"time"`
+	Date string    `json:"date"`
+	// Kind is "below", "above" or "warning".
+	Kind string `json:"kind"`
+	// Temperature and Threshold are set for threshold alerts.
+	Temperature string `json:"temperature,omitempty"`
+	Threshold   string `json:"threshold,omitempty"`
+	// Title, Severity, Description and Until are set for warnings.
+	Title       string     `json:"title,omitempty"`
+	Severity    string     `json:"severity,omitempty"`
+	Description string     `json:"description,omitempty"`
+	Until       *time.Time `json:"until,omitempty"`
+}
+
+type Forecast struct {
+	// Time is when the hour starts, in the forecast location's timezone.
+	Time time.Time `json:"time"`
+	// UTCTime is the same instant in UTC.
+	UTCTime             time.Time `json:"utc_time"`
+	Date                string    `json:"date"`
+	Temperature         string    `json:"temperature"`
+	Celsius             float64   `json:"celsius"`
+	SmoothedTemperature string    `json:"smoothed_temperature,omitempty"`
+	// Missing is set when open-meteo had no temperature for this hour.
+	// Temperature then holds Options.Missing and Celsius is meaningless.
+	Missing bool `json:"missing,omitempty"`
+	// Pressure is the surface pressure, usually in hPa, or Options.Missing if
+	// open-meteo didn't report one for this hour.
+	Pressure string `json:"pressure"`
+	// Precipitation (rain, showers and snow, in mm) and Snowfall (in cm) are
+	// the amounts expected over the preceding hour, or Options.Missing if
+	// unknown.
+	Precipitation string `json:"precipitation"`
+	Snowfall      string `json:"snowfall"`
+	// WindSpeed is the wind speed 10 m above ground, usually in km/h, or
+	// Options.Missing if unknown.
+	WindSpeed string `json:"wind_speed"`
+	// PrecipitationMM and WindKmh are Precipitation and WindSpeed converted
+	// to mm and km/h for calculations, or nil if unknown.
+	PrecipitationMM *float64 `json:"-"`
+	WindKmh         *float64 `json:"-"`
+}
+
+// DefaultMaxEntries allows for the longest forecast open-meteo offers: 16
+// days of hourly data.
+const DefaultMaxEntries = 16 * 24
+
+// Options controls how ExtractWeatherData turns a response into forecasts.
+type Options struct {
+	Format TemperatureFormat
+	// MaxEntries caps the number of hourly forecasts kept, so that a broken
+	// or hostile upstream can't make us allocate without bound. Zero or less
+	// means DefaultMaxEntries.
+	MaxEntries int
+	// MinCelsius and MaxCelsius bound the temperatures taken as real;
+	// readings outside them are dropped. Leaving both zero disables the
+	// check.
+	MinCelsius float64
+	MaxCelsius float64
+	// Missing is shown in place of values open-meteo reported as null.
+	Missing string
+}
+
+// DefaultMissing is what DefaultOptions shows for missing values.
+const DefaultMissing = "—"
+
+// DefaultMinCelsius and DefaultMaxCelsius are comfortably beyond the coldest
+// and hottest temperatures ever recorded.
+const (
+	DefaultMinCelsius = -90
+	DefaultMaxCelsius = 60
+)
+
+var DefaultOptions = Options{
+	Format:     DefaultFormat,
+	MaxEntries: DefaultMaxEntries,
+	MinCelsius: DefaultMinCelsius,
+	MaxCelsius: DefaultMaxCelsius,
+	Missing:    DefaultMissing,
+}
+
+// ExtractWeatherData formats an open-meteo forecast for display. Values are
+// rendered in the units hourly_units reports; a temperature reported in
+// Fahrenheit is converted back to Celsius first so that opts.Format decides
+// how it is shown.
+func ExtractWeatherData(city string, weatherResponse *WeatherResponse, opts Options) (WeatherDisplay, error) {
+	hourly := weatherResponse.Hourly
+	if len(hourly.Time) == 0 {
+		return WeatherDisplay{}, ErrNoForecastData
+	}
+	if len(hourly.Temperature2m) != len(hourly.Time) {
+		return WeatherDisplay{}, fmt.Errorf("malformed weather response: %d times but %d temperatures", len(hourly.Time), len(hourly.Temperature2m))
+	}
+
+	maxEntries := opts.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = DefaultMaxEntries
+	}
+	times, truncated := hourly.Time, false
+	if len(times) > maxEntries {
+		times, truncated = times[:maxEntries], true
+	}
+
+	loc := weatherResponse.location()
+	units := weatherResponse.HourlyUnits
+	pressureUnit := unitOr(units.SurfacePressure, "hPa")
+	precipitationUnit := unitOr(units.Precipitation, "mm")
+	snowfallUnit := unitOr(units.Snowfall, "cm")
+	windUnit := unitOr(units.WindSpeed10m, "km/h")
+	toCelsius := func(v float64) float64 { return v }
+	if units.Temperature2m == "°F" {
+		toCelsius = func(v float64) float64 { return (v - 32) * 5 / 9 }
+	}
+	checkPlausible := opts.MinCelsius != 0 || opts.MaxCelsius != 0
+	implausible := 0
+	forecasts := make([]Forecast, 0, len(times))
+	for i, t := range times {
+		date, err := time.ParseInLocation("2006-01-02T15:04", t, loc)
+		if err != nil {
+			return WeatherDisplay{}, fmt.Errorf("malformed weather response: %w", err)
+		}
+		forecast := Forecast{
+			Time:          date,
+			UTCTime:       date.UTC(),
+			Date:          date.Format("Mon, 2 Jan 15:04"),
+			Temperature:   opts.Missing,
+			Missing:       true,
+			Pressure:      opts.Missing,
+			Precipitation: opts.Missing,
+			Snowfall:      opts.Missing,
+			WindSpeed:     opts.Missing,
+		}
+		if reading := hourly.Temperature2m[i]; reading != nil {
+			temperature := toCelsius(*reading)
+			if checkPlausible && (temperature < opts.MinCelsius || temperature > opts.MaxCelsius) {
+				implausible++
+				continue
+			}
+			forecast.Temperature = opts.Format.Format(temperature)
+			forecast.Celsius = temperature
+			forecast.Missing = false
+		}
+		if i < len(hourly.SurfacePressure) && hourly.SurfacePressure[i] != nil {
+			forecast.Pressure = formatAmount(*hourly.SurfacePressure[i], 1, pressureUnit)
+		}
+		if i < len(hourly.Precipitation) && hourly.Precipitation[i] != nil {
+			forecast.Precipitation = formatAmount(*hourly.Precipitation[i], 1, precipitationUnit)
+			forecast.PrecipitationMM = convert(*hourly.Precipitation[i], millimetresPer[precipitationUnit])
+		}
+		if i < len(hourly.Snowfall) && hourly.Snowfall[i] != nil {
+			forecast.Snowfall = formatAmount(*hourly.Snowfall[i], 2, snowfallUnit)
+		}
+		if i < len(hourly.WindSpeed10m) && hourly.WindSpeed10m[i] != nil {
+			forecast.WindSpeed = formatAmount(*hourly.WindSpeed10m[i], 1, windUnit)
+			forecast.WindKmh = convert(*hourly.WindSpeed10m[i], kmhPer[windUnit])
+		}
+		forecasts = append(forecasts, forecast)
+	}
+	if len(forecasts) == 0 {
+		return WeatherDisplay{}, ErrNoForecastData
+	}
+	return WeatherDisplay{
+		City:                 city,
+		Timezone:             weatherResponse.Timezone,
+		TimezoneAbbreviation: weatherResponse.TimezoneAbbreviation,
+		Forecasts:            forecasts,
+		Truncated:            truncated,
+		Implausible:          implausible,
+	}, nil
+}
+
+// unitOr returns the unit open-meteo reported, or fallback (its default) for
+// responses that leave hourly_units out.
+func unitOr(reported, fallback string) string {
+	if reported == "" {
+		return fallback
+	}
+	return reported
+}
+
+// millimetresPer and kmhPer convert the precipitation and wind speed units
+// open-meteo offers to mm and km/h.
+var (
+	millimetresPer = map[string]float64{"mm": 1, "inch": 25.4}
+	kmhPer         = map[string]float64{"km/h": 1, "m/s": 3.6, "mp/h": 1.609344, "kn": 1.852}
+)
+
+// convert returns v times factor, or nil for an unknown unit's zero factor.
+func convert(v, factor float64) *float64 {
+	if factor == 0 {
+		return nil
+	}
+	v *= factor
+	return &v
+}
+
+// formatAmount renders v with the given number of decimals followed by unit.
+// It runs several times per forecast hour, so it avoids fmt.Sprintf.
+func formatAmount(v float64, decimals int, unit string) string {
+	buf := make([]byte, 0, 16)
+	buf = strconv.AppendFloat(buf, v, 'f', decimals, 64)
+	buf = append(buf, ' ')
+	buf = append(buf, unit...)
+	return string(buf)
+}
+
+// locations caches loaded timezones by name; time.LoadLocation reads the
+// zoneinfo database from disk on every call.
+var locations sync.Map
+
+// location returns the timezone open-meteo reported the hourly times in.
+// Times are local to the forecast location because we ask for timezone=auto.
+func (w WeatherResponse) location() *time.Location {
+	if loc, ok := locations.Load(w.Timezone); ok {
+		return loc.(*time.Location)
+	}
+	if loc, err := time.LoadLocation(w.Timezone); err == nil {
+		locations.Store(w.Timezone, loc)
+		return loc
+	}
+	return time.FixedZone(w.Timezone, w.UTCOffsetSeconds)
+}
+
+// ambiguityCandidates is how many matches FetchLatLong compares, and
+// ambiguousPopulationRatio how close in population the runner-up has to be
+// to the best match for the name to count as ambiguous.
+const (
+	ambiguityCandidates      = 5
+	ambiguousPopulationRatio = 0.5
+)
+
+// FetchLatLong asks the open-meteo geocoding API for the coordinates of city
+// and the place they belong to. If other matches are nearly as populous as
+// the best one, it returns an *AmbiguousCityError listing them instead of
+// guessing.
+func FetchLatLong(ctx context.Context, city string) (*Place, error) {
+	places, err := geocode(ctx, url.Values{"name": {city}}, ambiguityCandidates)
+	if err != nil {
+		return nil, err
+	}
+	best := places[0]
+	var candidates []Place
+	for _, place := range places[1:] {
+		if best.Population > 0 && float64(place.Population) >= ambiguousPopulationRatio*float64(best.Population) {
+			candidates = append(candidates, place)
+		}
+	}
+	if len(candidates) > 0 {
+		return nil, &AmbiguousCityError{City: city, Candidates: append([]Place{best}, candidates...)}
+	}
+	return &best, nil
+}
+
+// FetchPostalCode resolves a postal code to coordinates. country is an
+// optional ISO 3166-1 alpha-2 code that narrows the search, which matters
+// because the same code exists in many countries.
+func FetchPostalCode(ctx context.Context, code, country string) (*Place, error) {
+	query := url.Values{"name": {code}}
+	if country != "" {
+		query.Set("countryCode", strings.ToUpper(country))
+	}
+	places, err := geocode(ctx, query, 1)
+	if err != nil {
+		return nil, err
+	}
+	return &places[0], nil
+}
+
+// geocode returns up to count matches for query, best first, or ErrNoResults.
+func geocode(ctx context.Context, query url.Values, count int) ([]Place, error) {
+	query.Set("count", strconv.Itoa(count))
+	query.Set("language", "en")
+	query.Set("format", "json")
+	endpoint := GeocodingEndpoint + "?" + query.Encode()
+	resp, err := get(ctx, endpoint)
+	if err != nil {
+		return nil, requestFailed("Geo API", err)
+	}
+	defer resp.Body.Close()
+
+	var response GeoResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("error decoding response: %w", err)
+	}
+
+	if len(response.Results) < 1 {
+		return nil, ErrNoResults
+	}
+
+	return response.Results, nil
+}
+
+// Models lists the open-meteo weather models a forecast may be requested
+// from. Leaving the model empty lets open-meteo pick the best one for the
+// location.
+var Models = []string{
+	"best_match",
+	"ecmwf_ifs04",
+	"gem_seamless",
+	"gfs_seamless",
+	"icon_seamless",
+	"jma_seamless",
+	"meteofrance_seamless",
+	"metno_nordic",
+	"ukmo_seamless",
+}
+
+// ValidModel reports whether model is one of Models.
+func ValidModel(model string) bool {
+	for _, m := range Models {
+		if m == model {
+			return true
+		}
+	}
+	return false
+}
+
+// HourlyVariables are the hourly series every forecast request asks for.
+var HourlyVariables = []string{"temperature_2m", "surface_pressure", "precipitation", "snowfall", "wind_speed_10m"}
+
+// DailyVariables are the daily aggregates requested with ForecastParams.Daily.
+var DailyVariables = []string{"temperature_2m_max", "temperature_2m_min", "precipitation_sum"}
+
+// DefaultForecastDays is how many days are requested when ForecastParams
+// doesn't say, and MaxForecastDays the most open-meteo offers.
+const (
+	DefaultForecastDays = 3
+	MaxForecastDays     = 16
+)
+
+// ForecastParams are the optional settings of a forecast request. The zero
+// value asks for three days with open-meteo's defaults otherwise.
+type ForecastParams struct {
+	// Days is the number of forecast days, up to MaxForecastDays. Zero means
+	// DefaultForecastDays.
+	Days int
+	// Model is one of Models; empty uses open-meteo's automatic selection.
+	Model string
+	// Elevation, in metres, corrects temperatures for that altitude instead
+	// of the elevation of the model's grid cell.
+	Elevation *float64
+	// Daily also requests open-meteo's daily summary block.
+	Daily bool
+}
+
+// Encode returns everything about the request except the coordinates as a
+// normalized query string: parameters are sorted and defaults filled in, so
+// two requests for the same data encode the same. It is suitable as part of
+// a cache key.
+func (p ForecastParams) Encode() string {
+	days := p.Days
+	if days == 0 {
+		days = DefaultForecastDays
+	}
+	query := url.Values{
+		"hourly":        {strings.Join(HourlyVariables, ",")},
+		"timezone":      {"auto"},
+		"forecast_days": {strconv.Itoa(days)},
+	}
+	if p.Model != "" {
+		query.Set("models", p.Model)
+	}
+	if p.Elevation != nil {
+		query.Set("elevation", strconv.FormatFloat(*p.Elevation, 'f', 0, 64))
+	}
+	if p.Daily {
+		query.Set("daily", strings.Join(DailyVariables, ","))
+	}
+	return query.Encode()
+}
+
+// GetWeather fetches the hourly forecast (temperature, surface pressure,
+// precipitation, snowfall and wind speed) for latLong.
+func GetWeather(ctx context.Context, latLong LatLong, params ForecastParams) (*WeatherResponse, error) {
+	endpoint := ForecastURL(latLong, params)
+	resp, err := get(ctx, endpoint)
+	if err != nil {
+		return nil, requestFailed("Weather API", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, fmt.Errorf("%w: body ends after %d bytes", ErrTruncatedResponse, len(body))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %w", err)
+	}
+
+	return DecodeWeather(body)
+}
+
+// ForecastURL returns the open-meteo URL GetWeather requests.
+func ForecastURL(latLong LatLong, params ForecastParams) string {
+	return fmt.Sprintf("%s?latitude=%.6f&longitude=%.6f&%s", ForecastEndpoint, latLong.Latitude, latLong.Longitude, params.Encode())
+}
@@ -0,0 +1,657 @@
+package weather
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/text/language"
+)
+
+// serve points the open-meteo endpoints at handler for the rest of the test.
+func serve(t *testing.T, handler http.HandlerFunc) {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	geocoding, forecast := GeocodingEndpoint, ForecastEndpoint
+	GeocodingEndpoint, ForecastEndpoint = server.URL, server.URL
+	t.Cleanup(func() { GeocodingEndpoint, ForecastEndpoint = geocoding, forecast })
+}
+
+func TestStatusError(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		retryAfter string
+		body       string
+		want       StatusError
+	}{
+		{"maintenance", http.StatusServiceUnavailable, "120", `{"error":true,"reason":"down for maintenance"}`,
+			StatusError{Code: 503, Reason: "down for maintenance", RetryAfter: 2 * time.Minute}},
+		{"rate limited", http.StatusTooManyRequests, "1", `{"error":true,"reason":"too many requests"}`,
+			StatusError{Code: 429, Reason: "too many requests", RetryAfter: time.Second}},
+		{"bad request", http.StatusBadRequest, "", `{"error":true,"reason":"invalid latitude"}`,
+			StatusError{Code: 400, Reason: "invalid latitude"}},
+		{"html error page", http.StatusBadGateway, "soon", `<html>bad gateway</html>`,
+			StatusError{Code: 502}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serve(t, func(w http.ResponseWriter, r *http.Request) {
+				if tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			})
+			for api, fetch := range map[string]func() error{
+				"forecast": func() error {
+					_, err := GetWeather(context.Background(), LatLong{52.52, 13.41}, ForecastParams{})
+					return err
+				},
+				"geocoding": func() error { _, err := FetchLatLong(context.Background(), "Berlin"); return err },
+			} {
+				err := fetch()
+				var statusErr *StatusError
+				if !errors.As(err, &statusErr) {
+					t.Fatalf("%s: got error %v, want a *StatusError", api, err)
+				}
+				if *statusErr != tt.want {
+					t.Errorf("%s: got %+v, want %+v", api, *statusErr, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestFetchLatLongAmbiguous(t *testing.T) {
+	tests := []struct {
+		name        string
+		results     string
+		wantPlace   string
+		wantRegions []string // set if the name is ambiguous
+	}{
+		{"one match", `[{"name":"Berlin","country":"Germany","population":3426354}]`, "Berlin", nil},
+		{"clear favourite", `[
+			{"name":"Paris","country":"France","population":2138551},
+			{"name":"Paris","admin1":"Texas","country":"United States","population":24782}
+		]`, "Paris", nil},
+		{"close runners-up", `[
+			{"name":"Springfield","admin1":"Missouri","country":"United States","population":169176},
+			{"name":"Springfield","admin1":"Massachusetts","country":"United States","population":155929},
+			{"name":"Springfield","admin1":"Illinois","country":"United States","population":114230},
+			{"name":"Springfield","admin1":"Vermont","country":"United States","population":9062}
+		]`, "", []string{"Missouri", "Massachusetts", "Illinois"}},
+		{"unknown populations", `[{"name":"Neustadt","admin1":"Bavaria"},{"name":"Neustadt","admin1":"Saxony"}]`, "Neustadt", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serve(t, func(w http.ResponseWriter, r *http.Request) {
+				if got := r.URL.Query().Get("count"); got != "5" {
+					t.Errorf("asked for %s results, want 5", got)
+				}
+				w.Write([]byte(`{"results":` + tt.results + `}`))
+			})
+			place, err := FetchLatLong(context.Background(), "Springfield")
+			if tt.wantRegions == nil {
+				if err != nil || place.Name != tt.wantPlace {
+					t.Fatalf("got %+v, error %v; want %s", place, err, tt.wantPlace)
+				}
+				return
+			}
+
+			var ambiguous *AmbiguousCityError
+			if !errors.As(err, &ambiguous) || !errors.Is(err, ErrAmbiguousCity) {
+				t.Fatalf("got %+v, error %v; want an *AmbiguousCityError", place, err)
+			}
+			var regions []string
+			for _, candidate := range ambiguous.Candidates {
+				regions = append(regions, candidate.Admin1)
+			}
+			if !reflect.DeepEqual(regions, tt.wantRegions) {
+				t.Errorf("candidates in %q, want %q", regions, tt.wantRegions)
+			}
+		})
+	}
+}
+
+// gzipped compresses s.
+func gzipped(t *testing.T, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestGzippedResponses(t *testing.T) {
+	const forecast = `{"timezone":"GMT","hourly":{"time":["2024-01-01T00:00"],"temperature_2m":[1.5]}}`
+	var gzipOff bool
+	serve(t, func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Accept-Encoding"); got != "gzip" {
+			t.Errorf("Accept-Encoding = %q, want gzip", got)
+		}
+		body := forecast
+		if r.URL.Query().Has("name") {
+			body = `{"results":[{"name":"Berlin","latitude":52.52,"longitude":13.41}]}`
+		}
+		if gzipOff {
+			w.Write([]byte(body))
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(gzipped(t, body))
+	})
+
+	for _, off := range []bool{false, true} {
+		gzipOff = off
+		weather, err := GetWeather(context.Background(), LatLong{Latitude: 52.52, Longitude: 13.41}, ForecastParams{})
+		if err != nil {
+			t.Fatalf("GetWeather (gzip off: %t): %v", off, err)
+		}
+		if string(weather.Raw) != forecast || len(weather.Hourly.Temperature2m) != 1 || *weather.Hourly.Temperature2m[0] != 1.5 {
+			t.Errorf("GetWeather (gzip off: %t): got %s", off, weather.Raw)
+		}
+		place, err := FetchLatLong(context.Background(), "Berlin")
+		if err != nil || place.Name != "Berlin" || place.Latitude != 52.52 {
+			t.Errorf("FetchLatLong (gzip off: %t): got %+v, error %v", off, place, err)
+		}
+	}
+}
+
+func TestGzippedErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    []byte
+		wantErr func(error) bool
+	}{
+		{"error status", http.StatusBadRequest, gzipped(t, `{"error":true,"reason":"Latitude must be in range"}`), func(err error) bool {
+			var statusErr *StatusError
+			return errors.As(err, &statusErr) && statusErr.Reason == "Latitude must be in range"
+		}},
+		{"header cut off", http.StatusOK, gzipped(t, "{}")[:4], func(err error) bool {
+			return errors.Is(err, ErrTruncatedResponse)
+		}},
+		{"not gzip", http.StatusOK, []byte(`{"timezone":"GMT"}`), func(err error) bool {
+			return errors.Is(err, gzip.ErrHeader)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serve(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Encoding", "gzip")
+				w.WriteHeader(tt.status)
+				w.Write(tt.body)
+			})
+			_, err := GetWeather(context.Background(), LatLong{Latitude: 52.52, Longitude: 13.41}, ForecastParams{})
+			if !tt.wantErr(err) {
+				t.Errorf("got error %v", err)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"30", 30 * time.Second},
+		{"0", 0},
+		{"-5", 0},
+		{"tomorrow", 0},
+		{"Mon, 02 Jan 2006 15:04:05 GMT", 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+
+	future := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(future); got < 59*time.Minute || got > time.Hour {
+		t.Errorf("parseRetryAfter(%q) = %v, want about an hour", future, got)
+	}
+}
+
+func TestGetWeatherTimeout(t *testing.T) {
+	serve(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	})
+	latLong := LatLong{52.52, 13.41}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := GetWeather(ctx, latLong, ForecastParams{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("with a context deadline: got error %v, want context.DeadlineExceeded", err)
+	}
+
+	client := Client
+	Client = NewClient(nil, 20*time.Millisecond)
+	defer func() { Client = client }()
+	_, err := GetWeather(context.Background(), latLong, ForecastParams{})
+	var netErr interface{ Timeout() bool }
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("with a client timeout: got error %v, want a timeout", err)
+	}
+}
+
+// hourlyResponse returns a forecast with n hours of 10 °C starting at
+// midnight UTC on 1 January 2024.
+func hourlyResponse(n int) *WeatherResponse {
+	var resp WeatherResponse
+	resp.Timezone = "GMT"
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < n; i++ {
+		temperature := 10.0
+		resp.Hourly.Time = append(resp.Hourly.Time, start.Add(time.Duration(i)*time.Hour).Format("2006-01-02T15:04"))
+		resp.Hourly.Temperature2m = append(resp.Hourly.Temperature2m, &temperature)
+	}
+	return &resp
+}
+
+func TestExtractWeatherDataMaxEntries(t *testing.T) {
+	tests := []struct {
+		name          string
+		hours         int
+		maxEntries    int
+		wantForecasts int
+		wantTruncated bool
+	}{
+		{"under the cap", 72, 100, 72, false},
+		{"at the cap", 72, 72, 72, false},
+		{"over the cap", 72, 24, 24, true},
+		{"oversized payload", 100_000, DefaultMaxEntries, DefaultMaxEntries, true},
+		{"zero means the default", 1000, 0, DefaultMaxEntries, true},
+		{"negative means the default", 1000, -1, DefaultMaxEntries, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultOptions
+			opts.MaxEntries = tt.maxEntries
+			display, err := ExtractWeatherData("Berlin", hourlyResponse(tt.hours), opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(display.Forecasts) != tt.wantForecasts || display.Truncated != tt.wantTruncated {
+				t.Errorf("got %d forecasts, truncated %t; want %d, truncated %t",
+					len(display.Forecasts), display.Truncated, tt.wantForecasts, tt.wantTruncated)
+			}
+		})
+	}
+}
+
+// FuzzExtractWeatherData feeds arbitrary responses through DecodeWeather and
+// ExtractWeatherData. The seeds in testdata/fuzz are real and malformed
+// open-meteo payloads.
+func FuzzExtractWeatherData(f *testing.F) {
+	f.Fuzz(func(t *testing.T, raw []byte, maxEntries int) {
+		resp, err := DecodeWeather(raw)
+		if err != nil {
+			return
+		}
+		opts := DefaultOptions
+		opts.MaxEntries = maxEntries
+		display, err := ExtractWeatherData("Berlin", resp, opts)
+		if err != nil {
+			if len(display.Forecasts) != 0 {
+				t.Fatalf("got %d forecasts along with error %v", len(display.Forecasts), err)
+			}
+			return
+		}
+
+		limit := maxEntries
+		if limit <= 0 {
+			limit = DefaultMaxEntries
+		}
+		if len(display.Forecasts) == 0 || len(display.Forecasts) > limit {
+			t.Fatalf("got %d forecasts, want 1 to %d", len(display.Forecasts), limit)
+		}
+		if display.Truncated != (len(resp.Hourly.Time) > limit) {
+			t.Errorf("truncated %t for %d hours and a cap of %d", display.Truncated, len(resp.Hourly.Time), limit)
+		}
+		for _, forecast := range display.Forecasts {
+			if forecast.Missing {
+				continue
+			}
+			if forecast.Celsius < opts.MinCelsius || forecast.Celsius > opts.MaxCelsius {
+				t.Errorf("kept implausible temperature %v °C", forecast.Celsius)
+			}
+		}
+	})
+}
+
+// berlin7d is a 7-day hourly forecast as open-meteo returns it for Berlin.
+func berlin7d(b *testing.B) []byte {
+	b.Helper()
+	raw, err := os.ReadFile("testdata/berlin_7d.json")
+	if err != nil {
+		b.Fatal(err)
+	}
+	return raw
+}
+
+func BenchmarkDecodeWeather(b *testing.B) {
+	raw := berlin7d(b)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(raw)))
+	for i := 0; i < b.N; i++ {
+		if _, err := DecodeWeather(raw); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkExtractWeatherData(b *testing.B) {
+	resp, err := DecodeWeather(berlin7d(b))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ExtractWeatherData("Berlin", resp, DefaultOptions); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// withTemperatures returns hourlyResponse(len(temperatures)) with the given
+// readings, nil standing for null.
+func withTemperatures(temperatures ...*float64) *WeatherResponse {
+	resp := hourlyResponse(len(temperatures))
+	resp.Hourly.Temperature2m = temperatures
+	return resp
+}
+
+func celsius(v float64) *float64 { return &v }
+
+func TestExtractWeatherDataPlausibility(t *testing.T) {
+	tests := []struct {
+		name            string
+		min, max        float64
+		temperatures    []*float64
+		wantCelsius     []float64
+		wantImplausible int
+		wantErr         error
+	}{
+		{"within bounds", -90, 60, []*float64{celsius(-89), celsius(12), celsius(59.9)}, []float64{-89, 12, 59.9}, 0, nil},
+		{"bounds are inclusive", -90, 60, []*float64{celsius(-90), celsius(60)}, []float64{-90, 60}, 0, nil},
+		{"drops outliers", -90, 60, []*float64{celsius(12), celsius(999), celsius(-273), celsius(13)}, []float64{12, 13}, 2, nil},
+		{"keeps missing hours", -90, 60, []*float64{nil, celsius(999), celsius(10)}, []float64{0, 10}, 1, nil},
+		{"narrow bounds", 0, 30, []*float64{celsius(-1), celsius(15), celsius(31)}, []float64{15}, 2, nil},
+		{"disabled", 0, 0, []*float64{celsius(999), celsius(-273)}, []float64{999, -273}, 0, nil},
+		{"everything implausible", -90, 60, []*float64{celsius(100), celsius(200)}, nil, 0, ErrNoForecastData},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultOptions
+			opts.MinCelsius, opts.MaxCelsius = tt.min, tt.max
+			display, err := ExtractWeatherData("Berlin", withTemperatures(tt.temperatures...), opts)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if display.Implausible != tt.wantImplausible {
+				t.Errorf("got %d implausible hours, want %d", display.Implausible, tt.wantImplausible)
+			}
+			if len(display.Forecasts) != len(tt.wantCelsius) {
+				t.Fatalf("got %d forecasts, want %d", len(display.Forecasts), len(tt.wantCelsius))
+			}
+			for i, forecast := range display.Forecasts {
+				if forecast.Celsius != tt.wantCelsius[i] {
+					t.Errorf("forecast %d: got %v °C, want %v °C", i, forecast.Celsius, tt.wantCelsius[i])
+				}
+			}
+		})
+	}
+}
+
+func TestExtractWeatherDataMissingValues(t *testing.T) {
+	resp, err := DecodeWeather([]byte(`{
+		"timezone": "GMT",
+		"hourly_units": {"temperature_2m": "°C", "surface_pressure": "hPa", "precipitation": "mm", "wind_speed_10m": "km/h"},
+		"hourly": {
+			"time": ["2024-01-01T00:00", "2024-01-01T01:00", "2024-01-01T02:00", "2024-01-01T03:00"],
+			"temperature_2m": [1.5, null, 0.0, null],
+			"surface_pressure": [null, 1013.2, 1012.8, null],
+			"precipitation": [0.2, null, 0.0, 0.4],
+			"wind_speed_10m": [12.0, 8.5]
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type row struct {
+		temperature, pressure, precipitation, wind string
+		missing                                    bool
+	}
+	tests := []struct {
+		name    string
+		missing string
+		want    []row
+	}{
+		{"default placeholder", DefaultMissing, []row{
+			{"1.5°C", "—", "0.2 mm", "12.0 km/h", false},
+			{"—", "1013.2 hPa", "—", "8.5 km/h", true},
+			{"0.0°C", "1012.8 hPa", "0.0 mm", "—", false},
+			{"—", "—", "0.4 mm", "—", true},
+		}},
+		{"custom placeholder", "n/a", []row{
+			{"1.5°C", "n/a", "0.2 mm", "12.0 km/h", false},
+			{"n/a", "1013.2 hPa", "n/a", "8.5 km/h", true},
+			{"0.0°C", "1012.8 hPa", "0.0 mm", "n/a", false},
+			{"n/a", "n/a", "0.4 mm", "n/a", true},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultOptions
+			opts.Missing = tt.missing
+			display, err := ExtractWeatherData("Berlin", resp, opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(display.Forecasts) != len(tt.want) {
+				t.Fatalf("got %d forecasts, want %d", len(display.Forecasts), len(tt.want))
+			}
+			for i, f := range display.Forecasts {
+				got := row{f.Temperature, f.Pressure, f.Precipitation, f.WindSpeed, f.Missing}
+				if got != tt.want[i] {
+					t.Errorf("hour %d: got %+v, want %+v", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestTemperatureFormat(t *testing.T) {
+	german := language.MustParse("de")
+	tests := []struct {
+		name    string
+		format  TemperatureFormat
+		celsius float64
+		want    string
+	}{
+		{"default", DefaultFormat, 21.54, "21.5°C"},
+		{"half-even tie", TemperatureFormat{Decimals: 1, Rounding: RoundHalfEven}, 21.25, "21.2°C"},
+		{"half-up tie", TemperatureFormat{Decimals: 1, Rounding: RoundHalfUp}, 21.25, "21.3°C"},
+		{"half-up negative tie", TemperatureFormat{Decimals: 1, Rounding: RoundHalfUp}, -21.25, "-21.3°C"},
+		{"no decimals", TemperatureFormat{Decimals: 0}, 21.5, "22°C"},
+		{"half-even tie without decimals", TemperatureFormat{Decimals: 0, Rounding: RoundHalfEven}, 22.5, "22°C"},
+		{"half-up tie without decimals", TemperatureFormat{Decimals: 0, Rounding: RoundHalfUp}, 22.5, "23°C"},
+		{"two decimals", TemperatureFormat{Decimals: 2}, 21.125, "21.12°C"},
+		{"no negative zero", DefaultFormat, -0.04, "0.0°C"},
+		{"fahrenheit", TemperatureFormat{Decimals: 1, Unit: Fahrenheit}, 21.5, "70.7°F"},
+		{"fahrenheit at freezing", TemperatureFormat{Decimals: 0, Unit: Fahrenheit}, 0, "32°F"},
+		{"abbreviation", TemperatureFormat{Decimals: 1, Suffix: SuffixAbbreviation}, 21.5, "21.5C"},
+		{"word", TemperatureFormat{Decimals: 1, Suffix: SuffixWord, Unit: Fahrenheit}, 21.5, "70.7 fahrenheit"},
+		{"german", TemperatureFormat{Decimals: 1, Locale: german}, 21.5, "21,5 °C"},
+		{"german abbreviation", TemperatureFormat{Decimals: 1, Locale: german, Suffix: SuffixAbbreviation}, 21.5, "21,5 C"},
+		{"english locale", TemperatureFormat{Decimals: 1, Locale: language.English}, 1021.5, "1,021.5°C"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.format.Format(tt.celsius); got != tt.want {
+				t.Errorf("Format(%v) = %q, want %q", tt.celsius, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlaceFullName(t *testing.T) {
+	tests := []struct {
+		place Place
+		want  string
+	}{
+		{Place{Name: "Springfield", Admin1: "Illinois", Country: "United States"}, "Springfield, Illinois, United States"},
+		{Place{Name: "Berlin", Admin1: "Berlin", Country: "Germany"}, "Berlin, Germany"},
+		{Place{Name: "Singapore", Admin1: "Singapore", Country: "Singapore"}, "Singapore"},
+		{Place{Name: "Monaco", Country: "Monaco"}, "Monaco"},
+		{Place{Name: "Atlantis"}, "Atlantis"},
+		{Place{}, ""},
+	}
+	for _, tt := range tests {
+		if got := tt.place.FullName(); got != tt.want {
+			t.Errorf("%+v: got %q, want %q", tt.place, got, tt.want)
+		}
+	}
+}
+
+func TestTimezoneLabel(t *testing.T) {
+	tests := []struct {
+		timezone, abbreviation string
+		want                   string
+	}{
+		{"Europe/Berlin", "CEST", "Europe/Berlin"},
+		{"America/Argentina/Buenos_Aires", "-03", "-03"},
+		{"America/Argentina/Buenos_Aires", "", "America/Argentina/Buenos_Aires"},
+		{"", "GMT", "GMT"},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		display := WeatherDisplay{Timezone: tt.timezone, TimezoneAbbreviation: tt.abbreviation}
+		if got := display.TimezoneLabel(); got != tt.want {
+			t.Errorf("%q/%q: got %q, want %q", tt.timezone, tt.abbreviation, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeWeather(t *testing.T) {
+	tests := []struct {
+		name      string
+		raw       string
+		wantHours int
+		wantErr   error
+	}{
+		{"forecast", `{"timezone":"GMT","hourly":{"time":["2024-01-01T00:00"],"temperature_2m":[1.5]}}`, 1, nil},
+		{"nulls", `{"hourly":{"time":["2024-01-01T00:00","2024-01-01T01:00"],"temperature_2m":[null,2]}}`, 2, nil},
+		{"truncated", `{"timezone":"GMT","hourly":{"time":["2024-01-01T00:00"],"temp`, 0, ErrTruncatedResponse},
+		{"empty body", ``, 0, ErrTruncatedResponse},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := DecodeWeather([]byte(tt.raw))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if len(resp.Hourly.Time) != tt.wantHours || string(resp.Raw) != tt.raw {
+				t.Errorf("got %d hours and raw %q, want %d hours and the input", len(resp.Hourly.Time), resp.Raw, tt.wantHours)
+			}
+		})
+	}
+
+	if _, err := DecodeWeather([]byte(`{"hourly":{"time":"not a list"}}`)); err == nil || errors.Is(err, ErrTruncatedResponse) {
+		t.Errorf("mistyped field: got error %v, want a decoding error", err)
+	}
+}
+
+func TestWeatherResponseLocation(t *testing.T) {
+	tests := []struct {
+		timezone   string
+		offset     int
+		wantOffset int
+	}{
+		{"Europe/Berlin", 0, 3600},
+		{"GMT", 0, 0},
+		{"GMT+2", 7200, 7200},
+		{"Not/A_Zone", -18000, -18000},
+	}
+	winter := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		loc := WeatherResponse{Timezone: tt.timezone, UTCOffsetSeconds: tt.offset}.location()
+		if _, offset := winter.In(loc).Zone(); offset != tt.wantOffset {
+			t.Errorf("%s: got offset %d, want %d", tt.timezone, offset, tt.wantOffset)
+		}
+	}
+}
+
+func TestGroupByDayMissingValues(t *testing.T) {
+	resp, err := DecodeWeather([]byte(`{
+		"timezone": "GMT",
+		"hourly": {"time": ["2024-01-01T12:00", "2024-01-02T12:00"], "temperature_2m": [3.5, null]},
+		"daily_units": {"temperature_2m_max": "°C", "precipitation_sum": "mm"},
+		"daily": {
+			"time": ["2024-01-01", "2024-01-02", "2024-01-03"],
+			"temperature_2m_max": [4.5, null, 0.0],
+			"temperature_2m_min": [-1.0, null, null],
+			"precipitation_sum": [0.2, null, 1.0]
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Daily.Temperature2mMax[1] != nil || resp.Daily.Temperature2mMin[2] != nil {
+		t.Fatalf("null daily temperatures decoded as %v and %v, want nil", *resp.Daily.Temperature2mMax[1], *resp.Daily.Temperature2mMin[2])
+	}
+	display, err := ExtractWeatherData("Berlin", resp, DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	days, err := GroupByDay(resp, display.Forecasts, DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type row struct {
+		min, max, precipitation string
+		minCelsius, maxCelsius  *float64
+		hours                   int
+	}
+	want := []row{
+		{"-1.0°C", "4.5°C", "0.2 mm", celsius(-1), celsius(4.5), 1},
+		{"—", "—", "—", nil, nil, 1},
+		{"—", "0.0°C", "1.0 mm", nil, celsius(0), 0},
+	}
+	if len(days) != len(want) {
+		t.Fatalf("got %d days, want %d", len(days), len(want))
+	}
+	for i, day := range days {
+		got := row{day.Min, day.Max, day.Precipitation, day.MinCelsius, day.MaxCelsius, len(day.Hourly)}
+		if got.min != want[i].min || got.max != want[i].max || got.precipitation != want[i].precipitation ||
+			!reflect.DeepEqual(got.minCelsius, want[i].minCelsius) || !reflect.DeepEqual(got.maxCelsius, want[i].maxCelsius) ||
+			got.hours != want[i].hours {
+			t.Errorf("day %d: got %+v, want %+v", i, got, want[i])
+		}
+	}
+}